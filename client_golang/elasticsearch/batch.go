@@ -0,0 +1,320 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "fmt"
+    "net/url"
+    "sync"
+    "time"
+)
+
+// encodedDoc is a marshaled document along with its _id and routing key.
+type encodedDoc struct {
+    id      string
+    routing string
+    data    []byte
+}
+
+// docBatch holds the marshaled documents of one push cycle of a metric family.
+type docBatch struct {
+    urls  []string // Index URLs to send the documents to.
+    docs  []encodedDoc
+    bytes int64 // Total size of all documents.
+    log   Logger
+}
+
+func (b *docBatch) add(doc encodedDoc) {
+    b.docs = append(b.docs, doc)
+    b.bytes += int64(len(doc.data))
+}
+
+// withBatchTimeout returns a copy of ctx that is done once EsOpts.BatchTimeout,
+// if set, is exceeded.
+func (m *metricMap) withBatchTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+    if m.esOpts.BatchTimeout > 0 {
+        return context.WithTimeout(ctx, m.esOpts.BatchTimeout)
+    }
+    return context.WithCancel(ctx)
+}
+
+// sendBatch sends all documents of batch with one bulk request per index URL,
+// or PUTs them to each of its index URLs if EsOpts.PerDocument applies. Failures
+// are logged, independently per URL. Once ctx is done or EsOpts.BatchTimeout is
+// exceeded, the remaining documents are not sent anymore. If any document could
+// not be written, a *PushError is returned.
+func (m *metricMap) sendBatch(ctx context.Context, batch *docBatch) (err error) {
+    if len(batch.docs) == 0 {
+        return nil
+    }
+    m.pushMetrics.batchDocs.Observe(float64(len(batch.docs)))
+    if m.esOpts.BatchCallback != nil {
+        docs := make([]BatchDocument, 0, len(batch.docs))
+        for _, doc := range batch.docs {
+            docs = append(docs, BatchDocument{ID: doc.id, Doc: doc.data})
+        }
+        if err := m.esOpts.BatchCallback(batch.urls, docs); err != nil {
+            batch.log.Warnf("not pushing %s, rejected by BatchCallback: %v", m.desc.fqName, err)
+            return err
+        }
+    }
+    if m.esOpts.DryRun {
+        m.dryRun(batch)
+        return nil
+    }
+    if m.esOpts.NDJSONWriter != nil {
+        m.exportBatch(batch)
+        return nil
+    }
+    ctx, cancel := m.withBatchTimeout(ctx)
+    defer cancel()
+    sampler := m.newErrorSampler(batch.log)
+    defer sampler.summarize(m.desc.fqName)
+    defer func() { m.health.record(err, time.Now()) }() // See PushHealth.
+    pushErr := &PushError{FqName: m.desc.fqName, Total: len(batch.docs) * len(batch.urls)}
+    fail := func(docs int, err error) {
+        pushErr.Failed += docs
+        if pushErr.Err == nil {
+            pushErr.Err = err
+        }
+    }
+    var abortErr error // Set once ctx is done.
+    abort := func() {
+        if abortErr != nil {
+            return
+        }
+        batch.log.Errorf("aborting push of %s: %v", m.desc.fqName, ctx.Err())
+        abortErr = ctx.Err()
+        pushErr.Err = abortErr
+    }
+    if m.bulk() {
+        for _, url := range batch.urls {
+            if abortErr != nil {
+                fail(len(batch.docs), abortErr)
+                m.deadLetter(url, batch.docs, abortErr)
+                continue
+            }
+            lost, err := m.sendBulk(ctx, url, batch.docs)
+            m.deadLetter(url, lost, err)
+            if err != nil {
+                fail(len(lost), err)
+                if ctx.Err() != nil {
+                    abort()
+                    continue
+                }
+                sampler.warn(err)
+            }
+        }
+        return pushErr.orNil()
+    }
+    var mtx sync.Mutex // Protects the failure handling of the workers.
+    send := func(url string, doc encodedDoc) {
+        mtx.Lock()
+        if abortErr != nil {
+            fail(1, abortErr)
+            m.deadLetter(url, []encodedDoc{doc}, abortErr)
+            mtx.Unlock()
+            return
+        }
+        mtx.Unlock()
+        err := m.goRequest(ctx, m.docURL(url, doc), string(doc.data))
+        if err == nil {
+            return
+        }
+        mtx.Lock()
+        defer mtx.Unlock()
+        fail(1, err)
+        m.deadLetter(url, []encodedDoc{doc}, err)
+        if ctx.Err() != nil {
+            abort()
+            return
+        }
+        sampler.warn(fmt.Errorf("%v, document: %s", err, m.logBody(doc.data)))
+    }
+    workers := m.esOpts.PerDocumentWorkers
+    if workers < 1 {
+        workers = 1
+    }
+    type job struct {
+        url string
+        doc encodedDoc
+    }
+    jobs := make(chan job)
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := range jobs {
+                send(j.url, j.doc)
+            }
+        }()
+    }
+    for _, doc := range batch.docs {
+        for _, url := range batch.urls {
+            jobs <- job{url: url, doc: doc}
+        }
+    }
+    close(jobs)
+    wg.Wait()
+    return pushErr.orNil()
+}
+
+// defaultMaxBodyLogLength is the default of EsOpts.MaxBodyLogLength.
+const defaultMaxBodyLogLength = 1024
+
+// logBody returns the document body data for logging, truncated as configured
+// by EsOpts.MaxBodyLogLength.
+func (m *metricMap) logBody(data []byte) string {
+    max := m.esOpts.MaxBodyLogLength
+    if max == 0 {
+        max = defaultMaxBodyLogLength
+    }
+    return truncate(data, max)
+}
+
+// truncate returns data cut to max bytes, with the number of bytes cut off
+// appended. A negative max means no limit.
+func truncate(data []byte, max int) string {
+    if max < 0 || len(data) <= max {
+        return string(data)
+    }
+    return fmt.Sprintf("%s... (%d more bytes)", data[:max], len(data)-max)
+}
+
+// deadLetter passes the documents that could not be written to the index URL
+// url because of err to EsOpts.DeadLetterSink, if set.
+func (m *metricMap) deadLetter(url string, docs []encodedDoc, err error) {
+    if m.esOpts.DeadLetterSink == nil {
+        return
+    }
+    for _, doc := range docs {
+        m.esOpts.DeadLetterSink(DeadLetter{URL: url, ID: doc.id, Doc: doc.data, Err: err})
+    }
+}
+
+// asyncBuffer queues batches to be sent asynchronously. Its memory usage is
+// capped by the total size of the queued documents.
+type asyncBuffer struct {
+    maxBytes    int64 // 0 means unlimited.
+    pushMetrics *pushMetrics
+
+    mtx     sync.Mutex
+    cond    *sync.Cond // Signaled when a batch is queued or on close.
+    drained *sync.Cond // Broadcast when no batch is queued or in flight.
+    batches []*docBatch
+    bytes   int64 // Total size of queued and in-flight documents.
+    closed  bool
+}
+
+func newAsyncBuffer(maxBytes int64, pm *pushMetrics) *asyncBuffer {
+    b := &asyncBuffer{maxBytes: maxBytes, pushMetrics: pm}
+    b.cond = sync.NewCond(&b.mtx)
+    b.drained = sync.NewCond(&b.mtx)
+    return b
+}
+
+// enqueue queues batch. If that would exceed the configured maximum size, the
+// batch is dropped instead. It returns false without queuing batch if the
+// buffer has been closed, so that the caller has to send it itself.
+func (b *asyncBuffer) enqueue(batch *docBatch) bool {
+    b.mtx.Lock()
+    defer b.mtx.Unlock()
+
+    if b.closed {
+        return false
+    }
+    if b.maxBytes > 0 && b.bytes+batch.bytes > b.maxBytes {
+        b.pushMetrics.droppedDocs.Add(float64(len(batch.docs)))
+        batch.log.Warnf(
+            "dropping %d documents, %d bytes in flight plus %d bytes would exceed the limit of %d bytes",
+            len(batch.docs), b.bytes, batch.bytes, b.maxBytes,
+        )
+        return true
+    }
+    b.batches = append(b.batches, batch)
+    b.setBytes(b.bytes + batch.bytes)
+    b.cond.Signal()
+    return true
+}
+
+// run sends the queued batches one after another with send. It returns once
+// the buffer has been closed and all batches queued before have been sent.
+func (b *asyncBuffer) run(send func(*docBatch)) {
+    for {
+        b.mtx.Lock()
+        for len(b.batches) == 0 && !b.closed {
+            b.cond.Wait()
+        }
+        if len(b.batches) == 0 {
+            b.mtx.Unlock()
+            return
+        }
+        batch := b.batches[0]
+        b.batches[0] = nil
+        b.batches = b.batches[1:]
+        b.mtx.Unlock()
+
+        send(batch)
+
+        b.mtx.Lock()
+        b.setBytes(b.bytes - batch.bytes)
+        b.mtx.Unlock()
+    }
+}
+
+// close makes run return once the queued batches have been sent. Later
+// batches are not queued anymore, see enqueue.
+func (b *asyncBuffer) close() {
+    b.mtx.Lock()
+    defer b.mtx.Unlock()
+    b.closed = true
+    b.cond.Signal()
+}
+
+// wait blocks until no batch is queued or in flight anymore.
+func (b *asyncBuffer) wait() {
+    b.mtx.Lock()
+    defer b.mtx.Unlock()
+    for b.bytes > 0 || len(b.batches) > 0 {
+        b.drained.Wait()
+    }
+}
+
+// setBytes must be called while holding the mutex.
+func (b *asyncBuffer) setBytes(bytes int64) {
+    b.bytes = bytes
+    b.pushMetrics.bufferedBytes.Set(float64(bytes))
+    if bytes == 0 {
+        b.drained.Broadcast()
+    }
+}
+
+// docURL returns the URL of doc in the index addressed by the index URL u,
+// along with the query parameters naming EsOpts.Pipeline and the routing key
+// of doc, if set.
+func (m *metricMap) docURL(u string, doc encodedDoc) string {
+    query := url.Values{}
+    if m.esOpts.Pipeline != "" {
+        query.Set("pipeline", m.esOpts.Pipeline)
+    }
+    if doc.routing != "" {
+        query.Set("routing", doc.routing)
+    }
+    if len(query) == 0 {
+        return u + doc.id
+    }
+    return u + doc.id + "?" + query.Encode()
+}
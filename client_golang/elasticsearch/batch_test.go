@@ -0,0 +1,287 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "path"
+    "reflect"
+    "runtime"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    dto "github.com/Schneizelw/elasticsearch/client_model/go"
+)
+
+func TestAsyncBufferMaxInFlightBytes(t *testing.T) {
+    var (
+        received = make(chan struct{}, 10)
+        release  = make(chan struct{})
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        received <- struct{}{}
+        <-release
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{Async: true, MaxInFlightBytes: 150})
+    vec.WithLabelValues().Inc()
+
+    // The first push is in flight, blocking the buffer.
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    <-received
+    m := &dto.Metric{}
+    vec.pushMetrics.bufferedBytes.Write(m)
+    inFlight := m.GetGauge().GetValue()
+    if inFlight <= 0 || inFlight > 150 {
+        t.Fatalf("got %v buffered bytes, want a single document", inFlight)
+    }
+
+    // The second push would exceed the limit.
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.pushMetrics.droppedDocs.Write(m)
+    if got := m.GetCounter().GetValue(); got != 1 {
+        t.Errorf("got %v dropped documents, want 1", got)
+    }
+
+    close(release)
+    deadline := time.Now().Add(time.Second)
+    for {
+        vec.pushMetrics.bufferedBytes.Write(m)
+        if m.GetGauge().GetValue() == 0 {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("buffer not drained")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestAsyncBufferStop(t *testing.T) {
+    before := runtime.NumGoroutine()
+    var buf bytes.Buffer
+    vec := newTestCounterVec("http://localhost:9200/metrics/doc/", EsOpts{Async: true, NDJSONWriter: &buf})
+    vec.WithLabelValues().Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.Stop()
+
+    // The queued documents are sent before the goroutine exits.
+    waitForGoroutines(t, before)
+    vec.buffer.wait()
+    if n := len(bulkLines(buf.Bytes())); n != 2 {
+        t.Errorf("got %d bulk lines, want 2", n)
+    }
+
+    // After Stop, pushes are sent synchronously.
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if n := len(bulkLines(buf.Bytes())); n != 4 {
+        t.Errorf("got %d bulk lines after Stop, want 4", n)
+    }
+}
+
+// waitForGoroutines waits until no more than n goroutines are running.
+func waitForGoroutines(t *testing.T, n int) {
+    t.Helper()
+    deadline := time.Now().Add(time.Second)
+    for runtime.NumGoroutine() > n {
+        if time.Now().After(deadline) {
+            t.Fatalf("got %d goroutines, want at most %d", runtime.NumGoroutine(), n)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestBatchDocuments(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "status")
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.WithLabelValues("a").Inc()
+    vec.WithLabelValues("b").Inc()
+    vec.WithLabelValues("c").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    m := &dto.Metric{}
+    vec.pushMetrics.batchDocs.(Metric).Write(m)
+    if got := m.GetHistogram().GetSampleCount(); got != 1 {
+        t.Errorf("got %d batches, want 1", got)
+    }
+    if got := m.GetHistogram().GetSampleSum(); got != 3 {
+        t.Errorf("got %v documents, want 3", got)
+    }
+}
+
+func TestDeadLetterSink(t *testing.T) {
+    var (
+        mtx  sync.Mutex
+        seen = map[string]int{} // Attempts per document ID.
+    )
+    status := func(id string) int {
+        seen[id]++
+        switch {
+        case id == "bad":
+            return 400
+        case id == "flaky" && seen[id] == 1:
+            return 429
+        }
+        return 201
+    }
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        defer mtx.Unlock()
+        if r.URL.Path != "/_bulk" {
+            w.WriteHeader(status(path.Base(r.URL.Path)))
+            return
+        }
+        body, _ := ioutil.ReadAll(r.Body)
+        lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+        var items []map[string]bulkItem
+        for i := 0; i < len(lines); i += 2 {
+            var action map[string]bulkMeta
+            json.Unmarshal([]byte(lines[i]), &action)
+            id := action["index"].ID
+            items = append(items, map[string]bulkItem{"index": {WriteAck: WriteAck{ID: id}, Status: status(id)}})
+        }
+        json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": items})
+    })
+    defer server.Close()
+
+    for _, bulk := range []bool{false, true} {
+        var letters []DeadLetter
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+            PerDocument:    !bulk,
+            MaxRetries:     1,
+            BulkMaxRetries: 1,
+            IdLabel:        "id",
+            DeadLetterSink: func(l DeadLetter) { letters = append(letters, l) },
+        }, "id")
+        for _, id := range []string{"good", "bad", "flaky"} {
+            vec.WithLabelValues(id).Inc()
+        }
+        mtx.Lock()
+        seen = map[string]int{}
+        mtx.Unlock()
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+        if len(letters) != 1 {
+            t.Fatalf("bulk %t: got %d dead letters, want 1", bulk, len(letters))
+        }
+        l := letters[0]
+        if l.URL != server.URL+"/metrics/doc/" || l.ID != "bad" || l.Err == nil || !json.Valid(l.Doc) {
+            t.Errorf("bulk %t: unexpected dead letter %+v", bulk, l)
+        }
+    }
+}
+
+func TestLogBody(t *testing.T) {
+    body := []byte(strings.Repeat("x", 2000))
+    scenarios := []struct {
+        max  int
+        want string
+    }{
+        {max: 0, want: strings.Repeat("x", 1024) + "... (976 more bytes)"},
+        {max: 10, want: "xxxxxxxxxx... (1990 more bytes)"},
+        {max: 2000, want: string(body)},
+        {max: -1, want: string(body)},
+    }
+    for _, s := range scenarios {
+        vec := newTestCounterVec("", EsOpts{MaxBodyLogLength: s.max})
+        if got := vec.logBody(body); got != s.want {
+            t.Errorf("max %d: got %d bytes logged, want %d", s.max, len(got), len(s.want))
+        }
+    }
+}
+
+func TestBatchCallback(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    var (
+        calls int
+        ids   []string
+    )
+    reject := false
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        IdLabel: "callback_code",
+        BatchCallback: func(urls []string, docs []BatchDocument) error {
+            calls++
+            if reject {
+                return errors.New("rejected")
+            }
+            for _, doc := range docs {
+                ids = append(ids, doc.ID)
+            }
+            return nil
+        },
+    }, "callback_code")
+    vec.WithLabelValues("callback").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    reject = true
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    if calls != 2 || !reflect.DeepEqual(ids, []string{"callback"}) {
+        t.Errorf("got %d calls with documents %q, want 2 calls and the callback document", calls, ids)
+    }
+    if docs := server.docs(t); len(docs) != 1 {
+        t.Errorf("got %d documents, want 1 as the second push was rejected", len(docs))
+    }
+}
+
+func TestPerDocumentWorkers(t *testing.T) {
+    var (
+        mtx                     sync.Mutex
+        inFlight, max, requests int
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        requests++
+        inFlight++
+        if inFlight > max {
+            max = inFlight
+        }
+        mtx.Unlock()
+        time.Sleep(5 * time.Millisecond)
+        mtx.Lock()
+        inFlight--
+        mtx.Unlock()
+    })
+    defer server.Close()
+
+    const docs = 100
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{PerDocument: true, PerDocumentWorkers: 4}, "worker_code")
+    for i := 0; i < docs; i++ {
+        vec.WithLabelValues(strconv.Itoa(i)).Inc()
+    }
+    if err := vec.pushDocToEs(COUNTER_TYPE, DiscardLogger); err != nil {
+        t.Fatal(err)
+    }
+
+    mtx.Lock()
+    defer mtx.Unlock()
+    if requests != docs || inFlight != 0 {
+        t.Errorf("got %d requests, %d in flight after the push, want %d and 0", requests, inFlight, docs)
+    }
+    if max > 4 || max < 2 {
+        t.Errorf("got at most %d concurrent requests, want between 2 and 4", max)
+    }
+}
@@ -0,0 +1,287 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+)
+
+// bulkMeta is the metadata of an action of the bulk API.
+type bulkMeta struct {
+    Index    string `json:"_index"`
+    Type     string `json:"_type,omitempty"`
+    ID       string `json:"_id"`
+    Routing  string `json:"routing,omitempty"`
+    Pipeline string `json:"pipeline,omitempty"`
+}
+
+// bulkItem is the result of a single action of a bulk request.
+type bulkItem struct {
+    WriteAck
+    Status int `json:"status"`
+    Error  *struct {
+        Type   string `json:"type"`
+        Reason string `json:"reason"`
+    } `json:"error"`
+}
+
+// bulkResponse is the response to a bulk request.
+type bulkResponse struct {
+    Errors bool                  `json:"errors"`
+    Items  []map[string]bulkItem `json:"items"`
+}
+
+// bulkError is returned if some of the documents of a bulk request failed.
+type bulkError struct {
+    url      string
+    docs     int          // Number of documents sent.
+    failures []string     // Descriptions of the failed documents.
+    retry    []encodedDoc // Failed documents to be resent.
+    fatal    []encodedDoc // Failed documents not to be resent.
+}
+
+func (e *bulkError) Error() string {
+    return fmt.Sprintf(
+        "POST %s failed for %d of %d documents: %s",
+        e.url, len(e.failures), e.docs, strings.Join(e.failures, "; "),
+    )
+}
+
+// validateBulkUpsert returns an error if esOpts enables EsOpts.BulkUpsert
+// along with options it does not support.
+func validateBulkUpsert(esOpts EsOpts) error {
+    if !esOpts.BulkUpsert {
+        return nil
+    }
+    switch {
+    case esOpts.IdLabel == "":
+        return errors.New("BulkUpsert requires IdLabel to identify the documents to update")
+    case esOpts.Serverless:
+        return errors.New("BulkUpsert is not supported in serverless mode, data streams do not allow updating documents")
+    case esOpts.Pipeline != "":
+        return errors.New("BulkUpsert does not support Pipeline, update actions do not run ingest pipelines")
+    }
+    return nil
+}
+
+// bulk reports whether documents are sent with bulk requests, i.e. unless
+// EsOpts.PerDocument applies.
+func (m *metricMap) bulk() bool {
+    return !m.esOpts.PerDocument || m.esOpts.Serverless || m.esOpts.BulkUpsert
+}
+
+// bulkAction returns the action of the documents of bulk requests.
+func (m *metricMap) bulkAction() string {
+    switch {
+    case m.esOpts.Serverless:
+        return "create"
+    case m.esOpts.BulkUpsert:
+        return "update"
+    }
+    return "index"
+}
+
+// indexAndType extracts the index and the mapping type from an index URL as
+// built by BuildEsUrl.
+func indexAndType(u string) (string, string, error) {
+    parsed, err := url.Parse(u)
+    if err != nil {
+        return "", "", err
+    }
+    segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+    switch {
+    case len(segments) == 1 && segments[0] != "":
+        return segments[0], "", nil
+    case len(segments) == 2:
+        return segments[0], segments[1], nil
+    }
+    return "", "", fmt.Errorf("URL %q does not address an index", u)
+}
+
+// writeBulk appends the actions of docs to buf in the NDJSON format of the
+// bulk API, i.e. an action line followed by a source line per document. The
+// documents are written to the index addressed by the index URL u.
+func (m *metricMap) writeBulk(buf *bytes.Buffer, u string, docs []encodedDoc) error {
+    index, typ, err := indexAndType(u)
+    if err != nil {
+        return err
+    }
+    if m.esOpts.Serverless {
+        // Data streams have no mapping types.
+        typ = ""
+    }
+    for _, doc := range docs {
+        action, err := json.Marshal(map[string]bulkMeta{
+            m.bulkAction(): {
+                Index: index, Type: typ, ID: doc.id, Routing: doc.routing, Pipeline: m.esOpts.Pipeline,
+            },
+        })
+        if err != nil {
+            return err
+        }
+        buf.Write(action)
+        buf.WriteByte('\n')
+        if m.esOpts.BulkUpsert {
+            buf.WriteString(`{"doc":`)
+            buf.Write(doc.data)
+            buf.WriteString(`,"doc_as_upsert":true}`)
+        } else {
+            buf.Write(doc.data)
+        }
+        buf.WriteByte('\n')
+    }
+    return nil
+}
+
+// exportBatch writes all documents of batch for each of its index URLs to
+// EsOpts.NDJSONWriter in the format of the bulk API. The lines of a batch are
+// written at once.
+func (m *metricMap) exportBatch(batch *docBatch) {
+    var buf bytes.Buffer
+    for _, u := range batch.urls {
+        if err := m.writeBulk(&buf, u, batch.docs); err != nil {
+            batch.log.Errorf("not exporting %s: %v", m.desc.fqName, err)
+            return
+        }
+    }
+    if _, err := m.esOpts.NDJSONWriter.Write(buf.Bytes()); err != nil {
+        batch.log.Errorf("exporting %s: %v", m.desc.fqName, err)
+    }
+}
+
+// defaultBulkPath is the default of EsOpts.BulkPath.
+const defaultBulkPath = "_bulk"
+
+// bulkURL returns the URL of the bulk endpoint for the index URL u as
+// configured by EsOpts.BulkPath.
+func (m *metricMap) bulkURL(u string) (string, error) {
+    root, err := rootURL(u)
+    if err != nil {
+        return "", err
+    }
+    path := m.esOpts.BulkPath
+    if path == "" {
+        path = defaultBulkPath
+    }
+    if strings.Contains(path, indexPlaceholder) {
+        index, _, err := indexAndType(u)
+        if err != nil {
+            return "", err
+        }
+        path = strings.Replace(path, indexPlaceholder, index, -1)
+    }
+    return root + strings.TrimPrefix(path, "/"), nil
+}
+
+// indexPlaceholder is replaced by the index name in EsOpts.BulkPath.
+const indexPlaceholder = "{index}"
+
+// sendBulk indexes docs into the index addressed by the index URL u with a
+// request to the bulk API of its cluster. The request is retried like those of
+// goRequest, but as configured by EsOpts.BulkMaxRetries and
+// EsOpts.BulkRetryBackoff. If only some of the documents fail, only those that failed with a
+// retryable status code are resent. The documents that could not be written
+// are returned along with an error.
+func (m *metricMap) sendBulk(ctx context.Context, u string, docs []encodedDoc) ([]encodedDoc, error) {
+    bulkURL, err := m.bulkURL(u)
+    if err != nil {
+        return docs, err
+    }
+    var (
+        lost     []encodedDoc // Documents failed with a non-retryable status.
+        fatalErr error        // Error of the first request with such documents.
+    )
+    backoff := m.esOpts.BulkRetryBackoff
+    for retries := 0; ; retries++ {
+        err := m.postBulk(ctx, bulkURL, u, docs)
+        if err == nil {
+            return lost, fatalErr
+        }
+        be, partial := err.(*bulkError)
+        if partial {
+            if len(be.fatal) > 0 && fatalErr == nil {
+                fatalErr = err
+            }
+            lost = append(lost, be.fatal...)
+            docs = be.retry
+        }
+        if len(docs) == 0 || retries >= m.esOpts.BulkMaxRetries || !m.retryable(ctx, err) {
+            return append(lost, docs...), err
+        }
+        select {
+        case <-time.After(m.retryDelay(err, backoff)):
+        case <-ctx.Done():
+            return append(lost, docs...), err
+        }
+        backoff *= 2
+    }
+}
+
+// postBulk POSTs the index actions of docs for the index addressed by u to the
+// bulk endpoint bulkURL. If some of the documents failed, a *bulkError is
+// returned. The response items are correlated with docs by their order.
+func (m *metricMap) postBulk(ctx context.Context, bulkURL, u string, docs []encodedDoc) error {
+    var buf bytes.Buffer
+    if err := m.writeBulk(&buf, u, docs); err != nil {
+        return err
+    }
+    body, err := m.request(ctx, "POST", bulkURL, "application/x-ndjson", buf.Bytes())
+    if err != nil {
+        return err
+    }
+    var res bulkResponse
+    if err := json.Unmarshal(body, &res); err != nil {
+        return fmt.Errorf("decoding response of POST %s: %v", bulkURL, err)
+    }
+    if !res.Errors && m.esOpts.WriteAck == nil {
+        return nil
+    }
+    if len(res.Items) != len(docs) {
+        return fmt.Errorf(
+            "POST %s returned %d items for %d documents", bulkURL, len(res.Items), len(docs),
+        )
+    }
+
+    be := &bulkError{url: bulkURL, docs: len(docs)}
+    for i, item := range res.Items {
+        result := item[m.bulkAction()]
+        if result.Status/100 == 2 {
+            if m.esOpts.WriteAck != nil {
+                m.esOpts.WriteAck(result.WriteAck)
+            }
+            continue
+        }
+        failure := fmt.Sprintf("document %s: status %d", docs[i].id, result.Status)
+        if result.Error != nil {
+            failure += fmt.Sprintf(", %s: %s", result.Error.Type, result.Error.Reason)
+        }
+        be.failures = append(be.failures, failure)
+        if m.retryableStatus(result.Status) {
+            be.retry = append(be.retry, docs[i])
+        } else {
+            be.fatal = append(be.fatal, docs[i])
+        }
+    }
+    if len(be.failures) == 0 {
+        return nil
+    }
+    return be
+}
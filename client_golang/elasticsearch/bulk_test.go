@@ -0,0 +1,369 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "bytes"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "path"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+)
+
+func TestIndexAndType(t *testing.T) {
+    scenarios := []struct {
+        url, index, typ string
+        err             bool
+    }{
+        {url: "http://localhost:9200/metrics/doc/", index: "metrics", typ: "doc"},
+        {url: "http://localhost:9200/metrics/", index: "metrics"},
+        {url: "http://localhost:9200/", err: true},
+        {url: "http://localhost:9200/metrics/doc/1", err: true},
+    }
+    for _, s := range scenarios {
+        index, typ, err := indexAndType(s.url)
+        if s.err {
+            if err == nil {
+                t.Errorf("%s: expected an error", s.url)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: unexpected error: %s", s.url, err)
+        }
+        if index != s.index || typ != s.typ {
+            t.Errorf("%s: got index %q and type %q, want %q and %q", s.url, index, typ, s.index, s.typ)
+        }
+    }
+}
+
+func TestNDJSONWriter(t *testing.T) {
+    var buf bytes.Buffer
+    vec := newTestCounterVec("http://localhost:9200/metrics/doc/", EsOpts{
+        NDJSONWriter: &buf,
+        IdLabel:      "host",
+    }, "host")
+    vec.WithLabelValues("db1").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+    if len(lines) != 2 {
+        t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+    }
+    if want := `{"index":{"_index":"metrics","_type":"doc","_id":"db1"}}`; lines[0] != want {
+        t.Errorf("got action %s, want %s", lines[0], want)
+    }
+    doc := map[string]interface{}{}
+    if err := json.Unmarshal([]byte(lines[1]), &doc); err != nil {
+        t.Fatal(err)
+    }
+    if doc["host"] != "db1" || doc[TYPE] != METRIC_COUNTER {
+        t.Errorf("unexpected document %v", doc)
+    }
+}
+
+func TestBulkPartialFailure(t *testing.T) {
+    var (
+        mtx      sync.Mutex
+        requests [][]string // IDs of the documents of each bulk request.
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" || r.URL.Path != "/_bulk" {
+            t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+        }
+        body, _ := ioutil.ReadAll(r.Body)
+        lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+
+        mtx.Lock()
+        first := len(requests) == 0
+        var (
+            ids   []string
+            items []map[string]bulkItem
+        )
+        for i := 0; i < len(lines); i += 2 {
+            var action map[string]bulkMeta
+            if err := json.Unmarshal([]byte(lines[i]), &action); err != nil {
+                t.Error(err)
+            }
+            id := action["index"].ID
+            ids = append(ids, id)
+            status := 201
+            if first && id == "b" {
+                status = 429
+            }
+            if first && id == "c" {
+                status = 400
+            }
+            items = append(items, map[string]bulkItem{"index": {WriteAck: WriteAck{ID: id}, Status: status}})
+        }
+        requests = append(requests, ids)
+        mtx.Unlock()
+
+        json.NewEncoder(w).Encode(map[string]interface{}{"errors": first, "items": items})
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        BulkMaxRetries: 3,
+        IdLabel:        "id",
+    }, "id")
+    for _, id := range []string{"a", "b", "c"} {
+        vec.WithLabelValues(id).Inc()
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    mtx.Lock()
+    defer mtx.Unlock()
+    if len(requests) != 2 {
+        t.Fatalf("got %d bulk requests, want 2", len(requests))
+    }
+    sort.Strings(requests[0])
+    if want := []string{"a", "b", "c"}; !reflect.DeepEqual(requests[0], want) {
+        t.Errorf("got documents %q in the first request, want %q", requests[0], want)
+    }
+    if want := []string{"b"}; !reflect.DeepEqual(requests[1], want) {
+        t.Errorf("got documents %q in the retry, want %q", requests[1], want)
+    }
+}
+
+func TestWriteAck(t *testing.T) {
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/_bulk" {
+            w.Write([]byte(`{"errors":false,"items":[{"index":{"_index":"metrics","_id":"x","_version":3,"_seq_no":7,"_primary_term":1,"status":200}}]}`))
+            return
+        }
+        w.Write([]byte(`{"_index":"metrics","_id":"x","_version":2,"_seq_no":5,"_primary_term":1,"result":"updated"}`))
+    })
+    defer server.Close()
+
+    for _, bulk := range []bool{false, true} {
+        var acks []WriteAck
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+            PerDocument: !bulk,
+            IdLabel:     "id",
+            WriteAck:    func(ack WriteAck) { acks = append(acks, ack) },
+        }, "id")
+        vec.WithLabelValues("x").Inc()
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+        want := []WriteAck{{Index: "metrics", ID: "x", Version: 2, SeqNo: 5, PrimaryTerm: 1}}
+        if bulk {
+            want = []WriteAck{{Index: "metrics", ID: "x", Version: 3, SeqNo: 7, PrimaryTerm: 1}}
+        }
+        if !reflect.DeepEqual(acks, want) {
+            t.Errorf("bulk %t: got acks %+v, want %+v", bulk, acks, want)
+        }
+    }
+}
+
+func TestBulkMaxRetries(t *testing.T) {
+    var (
+        mtx      sync.Mutex
+        requests int
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        requests++
+        mtx.Unlock()
+        w.WriteHeader(http.StatusServiceUnavailable)
+    })
+    defer server.Close()
+
+    for _, s := range []struct {
+        esOpts EsOpts
+        want   int
+    }{
+        {EsOpts{MaxRetries: 3, BulkMaxRetries: 1, PerDocument: true}, 4},
+        {EsOpts{MaxRetries: 3, BulkMaxRetries: 1}, 2},
+    } {
+        mtx.Lock()
+        requests = 0
+        mtx.Unlock()
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", s.esOpts)
+        vec.WithLabelValues().Inc()
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+        mtx.Lock()
+        if requests != s.want {
+            t.Errorf("per document %t: got %d requests, want %d", s.esOpts.PerDocument, requests, s.want)
+        }
+        mtx.Unlock()
+    }
+}
+
+func TestBulkURL(t *testing.T) {
+    scenarios := []struct {
+        path string
+        want string
+    }{
+        {path: "", want: "http://localhost:9200/_bulk"},
+        {path: "{index}/_bulk", want: "http://localhost:9200/metrics/_bulk"},
+        {path: "/gateway/es/_bulk", want: "http://localhost:9200/gateway/es/_bulk"},
+    }
+    for _, s := range scenarios {
+        vec := newTestCounterVec("", EsOpts{BulkPath: s.path})
+        got, err := vec.bulkURL("http://localhost:9200/metrics/doc/")
+        if err != nil {
+            t.Errorf("path %q: unexpected error: %v", s.path, err)
+        } else if got != s.want {
+            t.Errorf("path %q: got %q, want %q", s.path, got, s.want)
+        }
+    }
+}
+
+func TestBulkUpsert(t *testing.T) {
+    var buf bytes.Buffer
+    vec := newTestCounterVec("http://localhost:9200/metrics/doc/", EsOpts{
+        NDJSONWriter: &buf,
+        BulkUpsert:   true,
+        IdLabel:      "host",
+    }, "host")
+    vec.WithLabelValues("upsert1").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+    if len(lines) != 2 {
+        t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+    }
+    if want := `{"update":{"_index":"metrics","_type":"doc","_id":"upsert1"}}`; lines[0] != want {
+        t.Errorf("got action %s, want %s", lines[0], want)
+    }
+    var source struct {
+        Doc         map[string]interface{} `json:"doc"`
+        DocAsUpsert bool                   `json:"doc_as_upsert"`
+    }
+    if err := json.Unmarshal([]byte(lines[1]), &source); err != nil {
+        t.Fatal(err)
+    }
+    if !source.DocAsUpsert || source.Doc["host"] != "upsert1" {
+        t.Errorf("unexpected source %s", lines[1])
+    }
+
+    if err := validateBulkUpsert(EsOpts{BulkUpsert: true}); err == nil {
+        t.Error("expected error for BulkUpsert without IdLabel")
+    }
+}
+
+func TestBulkByDefault(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    const n = 5
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "default_code")
+    for i := 0; i < n; i++ {
+        vec.WithLabelValues("default" + strconv.Itoa(i)).Inc()
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    server.mtx.Lock()
+    defer server.mtx.Unlock()
+    if len(server.requests) != 1 {
+        t.Fatalf("got %d requests for %d series, want 1", len(server.requests), n)
+    }
+    r := server.requests[0]
+    if r.method != "POST" || r.path != "/_bulk" {
+        t.Errorf("got request %s %s, want POST /_bulk", r.method, r.path)
+    }
+    if lines := bulkLines(r.body); len(lines) != 2*n {
+        t.Errorf("got %d lines, want %d", len(lines), 2*n)
+    }
+}
+
+func TestPipeline(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    for _, perDocument := range []bool{true, false} {
+        server.mtx.Lock()
+        server.requests = nil
+        server.mtx.Unlock()
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+            PerDocument: perDocument,
+            Pipeline:    "geo ip",
+            IdLabel:     "pipeline_code",
+        }, "pipeline_code")
+        vec.WithLabelValues("p1").Inc()
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+        server.mtx.Lock()
+        if len(server.requests) != 1 {
+            t.Fatalf("per document %t: got %d requests, want 1", perDocument, len(server.requests))
+        }
+        r := server.requests[0]
+        server.mtx.Unlock()
+        if perDocument {
+            if r.path != "/metrics/doc/p1" || r.query != "pipeline=geo+ip" {
+                t.Errorf("got request %s?%s, want /metrics/doc/p1?pipeline=geo+ip", r.path, r.query)
+            }
+            continue
+        }
+        if want := `{"index":{"_index":"metrics","_type":"doc","_id":"p1","pipeline":"geo ip"}}`; string(bulkLines(r.body)[0]) != want {
+            t.Errorf("got action %s, want %s", bulkLines(r.body)[0], want)
+        }
+    }
+
+    if err := validateBulkUpsert(EsOpts{BulkUpsert: true, IdLabel: "id", Pipeline: "geo"}); err == nil {
+        t.Error("expected error for BulkUpsert with Pipeline")
+    }
+}
+
+func TestRouting(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    for _, perDocument := range []bool{true, false} {
+        server.mtx.Lock()
+        server.requests = nil
+        server.mtx.Unlock()
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+            PerDocument:  perDocument,
+            IdLabel:      "routing_id",
+            RoutingLabel: "tenant",
+        }, "routing_id", "tenant")
+        vec.WithLabelValues("r1", "acme").Inc()
+        vec.WithLabelValues("r2", "").Inc()
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+        got := map[string]string{} // Routing key per document ID.
+        server.mtx.Lock()
+        for _, r := range server.requests {
+            if perDocument {
+                got[path.Base(r.path)] = r.query
+                continue
+            }
+            lines := bulkLines(r.body)
+            for i := 0; i < len(lines); i += 2 {
+                var action map[string]bulkMeta
+                if err := json.Unmarshal(lines[i], &action); err != nil {
+                    t.Fatal(err)
+                }
+                if action["index"].Routing != "" {
+                    got[action["index"].ID] = "routing=" + action["index"].Routing
+                } else {
+                    got[action["index"].ID] = ""
+                }
+            }
+        }
+        server.mtx.Unlock()
+        if want := map[string]string{"r1": "routing=acme", "r2": ""}; !reflect.DeepEqual(got, want) {
+            t.Errorf("per document %t: got routing %q, want %q", perDocument, got, want)
+        }
+    }
+}
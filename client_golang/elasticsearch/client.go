@@ -0,0 +1,112 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "bytes"
+    "compress/gzip"
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "time"
+)
+
+// newTransport returns the http.RoundTripper to send requests to Elasticsearch
+// with as configured by esOpts. It returns nil if the default transport is to
+// be used.
+func newTransport(esOpts EsOpts) (http.RoundTripper, error) {
+    if esOpts.ProxyURL == "" && esOpts.TLSConfig == nil {
+        return nil, nil
+    }
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    if esOpts.ProxyURL != "" {
+        proxyURL, err := url.Parse(esOpts.ProxyURL)
+        if err != nil {
+            return nil, fmt.Errorf("invalid proxy URL %q: %v", esOpts.ProxyURL, err)
+        }
+        transport.Proxy = http.ProxyURL(proxyURL)
+    }
+    if esOpts.TLSConfig != nil {
+        transport.TLSClientConfig = esOpts.TLSConfig.Clone()
+    }
+    return transport, nil
+}
+
+// defaultTimeout is the default of EsOpts.Timeout.
+const defaultTimeout = 10 * time.Second
+
+// newClient returns the http.Client to send all requests of a metric vector
+// with as configured by esOpts, i.e. EsOpts.HTTPClient if set.
+func newClient(esOpts EsOpts) (*http.Client, error) {
+    if esOpts.HTTPClient != nil {
+        if esOpts.ProxyURL != "" || esOpts.TLSConfig != nil || esOpts.Timeout != 0 {
+            return nil, errors.New("HTTPClient cannot be combined with ProxyURL, TLSConfig, or Timeout")
+        }
+        return esOpts.HTTPClient, nil
+    }
+    transport, err := newTransport(esOpts)
+    if err != nil {
+        return nil, err
+    }
+    timeout := esOpts.Timeout
+    if timeout == 0 {
+        timeout = defaultTimeout
+    }
+    if timeout < 0 {
+        timeout = 0
+    }
+    return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// warmUp sends a HEAD request to the root endpoint of each cluster the
+// metricMap pushes to, so that the first push finds an open connection. See
+// EsOpts.ConnectionWarmup. The responses are irrelevant, errors are only
+// logged. Nothing is sent if the metricMap does not push to a cluster, see
+// offline.
+func (m *metricMap) warmUp() {
+    if m.offline() {
+        return
+    }
+    seen := map[string]bool{}
+    for _, u := range append([]string{m.url}, m.fanOutURLs...) {
+        root, err := rootURL(u)
+        if err != nil || seen[root] {
+            continue
+        }
+        seen[root] = true
+        select {
+        case <-m.stop:
+            return
+        default:
+        }
+        if _, err := m.request(context.Background(), "HEAD", root, "application/json", nil); err != nil {
+            m.logger().Warnf("warming up connection to %s: %v", root, err)
+        }
+    }
+}
+
+// gzipBody returns the gzip-compressed body. See EsOpts.Gzip.
+func gzipBody(body []byte) ([]byte, error) {
+    var buf bytes.Buffer
+    zw := gzip.NewWriter(&buf)
+    if _, err := zw.Write(body); err != nil {
+        return nil, err
+    }
+    if err := zw.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
@@ -0,0 +1,278 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "compress/gzip"
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "io/ioutil"
+    "log"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestProxyURL(t *testing.T) {
+    var proxied []string
+    proxy := startServer(func(w http.ResponseWriter, r *http.Request) {
+        // A proxied request carries the absolute URL of the target.
+        proxied = append(proxied, r.URL.String())
+    })
+    defer proxy.Close()
+
+    vec := newTestCounterVec("http://es.invalid:9200/metrics/doc/", EsOpts{
+        ProxyURL:    proxy.URL,
+        PerDocument: true,
+    })
+    vec.WithLabelValues().Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    if len(proxied) != 1 || !strings.HasPrefix(proxied[0], "http://es.invalid:9200/metrics/doc/") {
+        t.Errorf("got proxied requests %q, want one to the document URL", proxied)
+    }
+
+    if _, err := newTransport(EsOpts{ProxyURL: "://bad"}); err == nil {
+        t.Error("expected error for invalid proxy URL")
+    }
+}
+
+func TestWarmUp(t *testing.T) {
+    var (
+        mtx   sync.Mutex
+        heads []string
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        defer mtx.Unlock()
+        heads = append(heads, r.Method+" "+r.URL.Path)
+    })
+    defer server.Close()
+
+    host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        Host:   host,
+        Port:   port,
+        EsType: "doc",
+        FanOut: []FanOutIndex{{Index: "archive"}},
+    })
+    if len(vec.fanOutURLs) != 1 {
+        t.Fatalf("got fan-out URLs %q, want one", vec.fanOutURLs)
+    }
+    vec.log = DiscardLogger
+    vec.warmUp()
+
+    mtx.Lock()
+    defer mtx.Unlock()
+    if len(heads) != 1 || heads[0] != "HEAD /" {
+        t.Errorf("got requests %q, want a single HEAD of the root endpoint", heads)
+    }
+}
+
+func TestWarmUpOffline(t *testing.T) {
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        t.Errorf("got request %s %s while offline", r.Method, r.URL.Path)
+    })
+    defer server.Close()
+
+    for _, esOpts := range []EsOpts{
+        {DryRun: true, DryRunWriter: ioutil.Discard},
+        {NDJSONWriter: ioutil.Discard},
+    } {
+        esOpts.ConnectionWarmup = true
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", esOpts)
+        vec.log = DiscardLogger
+        vec.warmUp()
+    }
+}
+
+func TestSharedClient(t *testing.T) {
+    var (
+        mtx   sync.Mutex
+        conns = map[string]bool{}
+    )
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        defer mtx.Unlock()
+        conns[r.RemoteAddr] = true
+    }))
+    defer server.Close()
+    defer server.CloseClientConnections()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "shared_code")
+    vec.log = DiscardLogger
+    for i := 0; i < 5; i++ {
+        vec.WithLabelValues(strconv.Itoa(i)).Inc()
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    mtx.Lock()
+    defer mtx.Unlock()
+    if len(conns) != 1 {
+        t.Errorf("got %d connections for 10 sequential requests, want 1", len(conns))
+    }
+}
+
+func TestTimeout(t *testing.T) {
+    release := make(chan struct{})
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        <-release
+    })
+    defer server.Close()
+    defer close(release)
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{Timeout: 50 * time.Millisecond})
+    start := time.Now()
+    err := vec.goRequest(context.Background(), server.URL+"/metrics/doc/1", "{}")
+    if err == nil {
+        t.Fatal("expected timeout error")
+    }
+    if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+        t.Errorf("got error %v, want a timeout error", err)
+    }
+    if elapsed := time.Since(start); elapsed > 5*time.Second {
+        t.Errorf("request returned after %v", elapsed)
+    }
+
+    if c, _ := newClient(EsOpts{}); c.Timeout != defaultTimeout {
+        t.Errorf("got default timeout %v, want %v", c.Timeout, defaultTimeout)
+    }
+    if c, _ := newClient(EsOpts{Timeout: -1}); c.Timeout != 0 {
+        t.Errorf("got timeout %v, want none", c.Timeout)
+    }
+}
+
+func TestMalformedURL(t *testing.T) {
+    vec := newTestCounterVec("http://localhost:9200/metrics/doc/", EsOpts{})
+    if err := vec.goRequest(context.Background(), "http://%zz/metrics/doc/1", "{}"); err == nil {
+        t.Error("expected error for a malformed URL")
+    }
+}
+
+func TestTLSConfig(t *testing.T) {
+    server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`{"errors":false,"items":[]}`))
+    }))
+    server.Config.SetKeepAlivesEnabled(false)
+    server.Config.ErrorLog = log.New(ioutil.Discard, "", 0) // Failed handshakes.
+    server.StartTLS()
+    defer server.Close()
+
+    roots := x509.NewCertPool()
+    roots.AddCert(server.Certificate())
+    for _, s := range []struct {
+        tlsConfig *tls.Config
+        wantOK    bool
+    }{
+        {nil, false},
+        {&tls.Config{RootCAs: roots}, true},
+        {&tls.Config{InsecureSkipVerify: true}, true},
+    } {
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{TLSConfig: s.tlsConfig}, "tls_code")
+        vec.log = DiscardLogger
+        vec.WithLabelValues("tls").Inc()
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+        if h := vec.PushHealth(); (h.LastError == nil) != s.wantOK {
+            t.Errorf("TLS config %v: got error %v, want success %t", s.tlsConfig, h.LastError, s.wantOK)
+        }
+    }
+}
+
+func TestGzip(t *testing.T) {
+    var (
+        mtx      sync.Mutex
+        encoding string
+        body     []byte
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        defer mtx.Unlock()
+        encoding = r.Header.Get("Content-Encoding")
+        zr, err := gzip.NewReader(r.Body)
+        if err != nil {
+            t.Error(err)
+            return
+        }
+        if body, err = ioutil.ReadAll(zr); err != nil {
+            t.Error(err)
+        }
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{Gzip: true, PerDocument: true}, "gzip_code")
+    vec.log = DiscardLogger
+    vec.WithLabelValues("gzip").Add(2)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    mtx.Lock()
+    defer mtx.Unlock()
+    if encoding != "gzip" {
+        t.Errorf("got Content-Encoding %q, want gzip", encoding)
+    }
+    doc := map[string]interface{}{}
+    if err := json.Unmarshal(body, &doc); err != nil {
+        t.Fatalf("decompressed body %q: %v", body, err)
+    }
+    if doc["gzip_code"] != "gzip" || doc[VALUE] != 2.0 {
+        t.Errorf("unexpected document %v", doc)
+    }
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+    return f(req)
+}
+
+func TestHTTPClient(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    var (
+        mtx   sync.Mutex
+        calls int
+    )
+    client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+        mtx.Lock()
+        calls++
+        mtx.Unlock()
+        return http.DefaultTransport.RoundTrip(req)
+    })}
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{HTTPClient: client}, "client_code")
+    vec.log = DiscardLogger
+    vec.WithLabelValues("client").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    mtx.Lock()
+    defer mtx.Unlock()
+    if calls != 1 {
+        t.Errorf("got %d round trips, want 1", calls)
+    }
+    if docs := server.docs(t); len(docs) != 1 {
+        t.Errorf("got %d documents, want 1", len(docs))
+    }
+
+    if _, err := newClient(EsOpts{HTTPClient: client, Timeout: time.Second}); err == nil {
+        t.Error("expected error for HTTPClient with Timeout")
+    }
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/url"
+    "strings"
+)
+
+// rootURL returns the root endpoint of the cluster the document URL u points
+// to, i.e. u stripped of its path.
+func rootURL(u string) (string, error) {
+    parsed, err := url.Parse(u)
+    if err != nil {
+        return "", err
+    }
+    if parsed.Scheme == "" || parsed.Host == "" {
+        return "", fmt.Errorf("URL %q lacks scheme or host", u)
+    }
+    return parsed.Scheme + "://" + parsed.Host + "/", nil
+}
+
+// validateIndexURL returns an error if u is not an HTTP or HTTPS URL
+// addressing an index. As the URLs of the documents are u followed by their
+// _id, u must end with a "/" and must not have a query or fragment.
+func validateIndexURL(u string) error {
+    parsed, err := url.Parse(u)
+    if err != nil {
+        return fmt.Errorf("invalid index URL %q: %v", u, err)
+    }
+    if parsed.Scheme != "http" && parsed.Scheme != "https" {
+        return fmt.Errorf("index URL %q must use http or https", u)
+    }
+    if parsed.RawQuery != "" || parsed.Fragment != "" || parsed.ForceQuery {
+        return fmt.Errorf("index URL %q must not have a query or fragment", u)
+    }
+    if !strings.HasSuffix(parsed.Path, "/") {
+        return fmt.Errorf("index URL %q must end with a \"/\" to append the document _id to", u)
+    }
+    if _, err := rootURL(u); err != nil {
+        return err
+    }
+    _, _, err = indexAndType(u)
+    return err
+}
+
+// clusterName queries the root endpoint of the cluster the metricMap pushes to
+// and returns the name the cluster reports.
+func (m *metricMap) clusterName(ctx context.Context) (string, error) {
+    root, err := rootURL(m.url)
+    if err != nil {
+        return "", err
+    }
+    body, err := m.request(ctx, "GET", root, "application/json", nil)
+    if err != nil {
+        return "", err
+    }
+    var info struct {
+        ClusterName string `json:"cluster_name"`
+    }
+    if err := json.Unmarshal(body, &info); err != nil {
+        return "", fmt.Errorf("decoding response of GET %s: %v", root, err)
+    }
+    return info.ClusterName, nil
+}
+
+// ClusterHealth is the health of a cluster as reported by its _cluster/health
+// endpoint.
+type ClusterHealth struct {
+    ClusterName string `json:"cluster_name"`
+    // Status is "green", "yellow", or "red".
+    Status string `json:"status"`
+}
+
+// Ping queries the health of the cluster this vector pushes to, e.g. for a
+// readiness probe. An error is returned if the cluster cannot be reached or
+// does not respond successfully. A red cluster is reachable but may reject
+// writes.
+func (m *metricMap) Ping(ctx context.Context) (ClusterHealth, error) {
+    var health ClusterHealth
+    root, err := rootURL(m.url)
+    if err != nil {
+        return health, err
+    }
+    u := root + "_cluster/health"
+    body, err := m.request(ctx, "GET", u, "application/json", nil)
+    if err != nil {
+        return health, err
+    }
+    if err := json.Unmarshal(body, &health); err != nil {
+        return health, fmt.Errorf("decoding response of GET %s: %v", u, err)
+    }
+    return health, nil
+}
+
+// pingBeforePush returns an error if the cluster is unreachable or red. See
+// EsOpts.PingBeforePush. Cancelling ctx aborts the ping.
+func (m *metricMap) pingBeforePush(ctx context.Context) error {
+    health, err := m.Ping(ctx)
+    if err != nil {
+        return fmt.Errorf("not pushing %s, cannot ping cluster: %v", m.desc.fqName, err)
+    }
+    if health.Status == "red" {
+        return fmt.Errorf("not pushing %s, cluster %s is red", m.desc.fqName, health.ClusterName)
+    }
+    return nil
+}
+
+// Verify checks that the cluster this vector pushes to is named
+// EsOpts.ClusterName, e.g. at startup to fail fast on a misconfigured URL.
+// Cancelling ctx aborts the check. Otherwise, the check is done before the
+// first push. Once it has succeeded, it is not repeated. Verify returns nil if
+// EsOpts.ClusterName is not set or nothing is pushed to a cluster (see
+// EsOpts.DryRun).
+func (m *metricMap) Verify(ctx context.Context) error {
+    return m.verifyCluster(ctx)
+}
+
+// verifyCluster checks that the cluster pushed to is named EsOpts.ClusterName.
+// Once the check has succeeded, it is not repeated. If it fails, nothing must
+// be pushed, and the check is repeated on the next push. The lock is not held
+// during the request, so that a slow cluster does not block other pushes.
+func (m *metricMap) verifyCluster(ctx context.Context) error {
+    if m.esOpts.ClusterName == "" || m.offline() {
+        return nil
+    }
+    m.pushMtx.Lock()
+    verified := m.clusterVerified
+    m.pushMtx.Unlock()
+    if verified {
+        return nil
+    }
+    name, err := m.clusterName(ctx)
+    if err != nil {
+        return fmt.Errorf("not pushing %s, cannot verify cluster name: %v", m.desc.fqName, err)
+    }
+    if name != m.esOpts.ClusterName {
+        return fmt.Errorf(
+            "not pushing %s, cluster is named %q but %q was expected",
+            m.desc.fqName, name, m.esOpts.ClusterName,
+        )
+    }
+    m.pushMtx.Lock()
+    m.clusterVerified = true
+    m.pushMtx.Unlock()
+    return nil
+}
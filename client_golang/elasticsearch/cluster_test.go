@@ -0,0 +1,254 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "net/http"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestVerifyCluster(t *testing.T) {
+    var docs int
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/" {
+            w.Write([]byte(`{"cluster_name":"staging","version":{"number":"7.3.0"}}`))
+            return
+        }
+        docs++
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{ClusterName: "prod"})
+    vec.WithLabelValues().Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if docs != 0 {
+        t.Errorf("pushed %d documents to the wrong cluster", docs)
+    }
+
+    vec = newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{ClusterName: "staging"})
+    vec.WithLabelValues().Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if docs != 1 {
+        t.Errorf("got %d documents, want 1", docs)
+    }
+}
+
+func TestVerify(t *testing.T) {
+    var (
+        mtx      sync.Mutex
+        requests int
+        release  = make(chan struct{})
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/" {
+            w.Write([]byte(`{"errors":false,"items":[]}`))
+            return
+        }
+        mtx.Lock()
+        requests++
+        first := requests == 1
+        mtx.Unlock()
+        if first {
+            // A hung cluster, until the test is done.
+            <-release
+        }
+        w.Write([]byte(`{"cluster_name":"prod"}`))
+    })
+    defer server.Close()
+    defer close(release)
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{ClusterName: "prod"})
+    vec.log = DiscardLogger
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    if err := vec.Verify(ctx); err == nil {
+        t.Fatal("expected an error once the context is done")
+    }
+
+    mtx.Lock()
+    requests = 0
+    mtx.Unlock()
+    go vec.Verify(context.Background())
+    for {
+        mtx.Lock()
+        n := requests
+        mtx.Unlock()
+        if n == 1 {
+            break
+        }
+        time.Sleep(time.Millisecond)
+    }
+    vec.WithLabelValues().Inc()
+    done := make(chan error)
+    go func() { done <- vec.PushContext(context.Background()) }()
+    select {
+    case err := <-done:
+        if err != nil {
+            t.Error(err)
+        }
+    case <-time.After(time.Second):
+        t.Error("push blocked by the verification in progress")
+    }
+}
+
+func TestClusterName(t *testing.T) {
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("X-Tenant") != "team-a" {
+            w.WriteHeader(http.StatusForbidden)
+            return
+        }
+        w.Write([]byte(`{"cluster_name":"prod"}`))
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        ClusterName: "prod",
+        Headers:     map[string]string{"X-Tenant": "team-a"},
+    })
+    if name, err := vec.clusterName(context.Background()); err != nil || name != "prod" {
+        t.Errorf("got cluster name %q, %v, want prod", name, err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, err := vec.clusterName(ctx); err == nil {
+        t.Error("expected an error for a cancelled context")
+    }
+}
+
+func TestRootURL(t *testing.T) {
+    got, err := rootURL("http://localhost:9200/metrics/doc/")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := "http://localhost:9200/"; got != want {
+        t.Errorf("got %q, want %q", got, want)
+    }
+    if _, err := rootURL("localhost:9200/metrics"); err == nil {
+        t.Error("expected error for URL without scheme")
+    }
+}
+
+func TestValidateIndexURL(t *testing.T) {
+    for _, u := range []string{
+        "http://localhost:9200/metrics/doc/",
+        "https://security-es:9243/audit/_doc/",
+        "https://security-es:9243/audit/",
+    } {
+        if err := validateIndexURL(u); err != nil {
+            t.Errorf("%s: unexpected error: %v", u, err)
+        }
+    }
+    for _, u := range []string{
+        "htp://localhost:9200/metrics/doc/",
+        "http:///metrics/doc/",
+        "http://localhost:9200/",
+        "http://localhost:9200/a/b/c/",
+        "://localhost",
+        "http://localhost:9200/metrics",
+        "http://localhost:9200/metrics/doc",
+        "http://localhost:9200/metrics/?pretty",
+        "http://localhost:9200/metrics/#doc",
+    } {
+        if err := validateIndexURL(u); err == nil {
+            t.Errorf("%s: expected error", u)
+        }
+    }
+}
+
+func TestURL(t *testing.T) {
+    esOpts := EsOpts{
+        URL:    "https://security-es:9243/audit/_doc/",
+        Host:   "metrics-es",
+        Port:   "9200",
+        FanOut: []FanOutIndex{{Index: "archive"}},
+    }
+    if got := indexURL(esOpts); got != esOpts.URL {
+        t.Errorf("got index URL %q, want %q", got, esOpts.URL)
+    }
+    if got, want := fanOutURLs(esOpts), []string{"https://security-es:9243/archive/_doc/"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("got fan-out URLs %q, want %q", got, want)
+    }
+}
+
+func TestPing(t *testing.T) {
+    var (
+        mtx    sync.Mutex
+        status = "green"
+        docs   int
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        defer mtx.Unlock()
+        if r.URL.Path == "/_cluster/health" {
+            w.Write([]byte(`{"cluster_name":"staging","status":"` + status + `"}`))
+            return
+        }
+        docs++
+        w.Write([]byte(`{"errors":false,"items":[]}`))
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{PingBeforePush: true}, "ping_code")
+    vec.WithLabelValues("ping").Inc()
+    for _, want := range []string{"green", "red"} {
+        mtx.Lock()
+        status = want
+        docs = 0
+        mtx.Unlock()
+
+        health, err := vec.Ping(context.Background())
+        if err != nil {
+            t.Fatal(err)
+        }
+        if health.Status != want || health.ClusterName != "staging" {
+            t.Errorf("got health %+v, want status %s of staging", health, want)
+        }
+        pushErr := vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+        mtx.Lock()
+        if pushed := docs > 0; pushed != (want == "green") || (pushErr == nil) != pushed {
+            t.Errorf("status %s: got %d bulk requests and error %v", want, docs, pushErr)
+        }
+        mtx.Unlock()
+    }
+
+    server.Close()
+    if _, err := vec.Ping(context.Background()); err == nil {
+        t.Error("expected error for unreachable cluster")
+    }
+}
+
+func TestNewMetricVecIndexURL(t *testing.T) {
+    newVec := func(u string) (err error) {
+        defer func() {
+            if r := recover(); r != nil {
+                err, _ = r.(error)
+            }
+        }()
+        newTestCounterVec(u, EsOpts{})
+        return nil
+    }
+    if err := newVec("http://localhost:9200/metrics/_doc/"); err != nil {
+        t.Errorf("unexpected error: %v", err)
+    }
+    err := newVec("http://localhost:9200/metrics")
+    if err == nil || !strings.Contains(err.Error(), `must end with a "/"`) {
+        t.Errorf("got error %v for an index URL without a trailing separator", err)
+    }
+}
@@ -14,6 +14,7 @@
 package elasticsearch
 
 import (
+    "context"
     _ "fmt"
     "errors"
     "math"
@@ -119,6 +120,13 @@ func (c *counter) Write(out *dto.Metric) error {
     return populateMetric(CounterValue, val, c.labelPairs, out)
 }
 
+func (c *counter) exactValue() (uint64, bool) {
+    if atomic.LoadUint64(&c.valBits) != 0 {
+        return 0, false
+    }
+    return atomic.LoadUint64(&c.valInt), true
+}
+
 // CounterVec is a Collector that bundles a set of Counters that all share the
 // same Desc, but have different values for their variable labels. This is used
 // if you want to count the same thing partitioned by various dimensions
@@ -137,9 +145,9 @@ func NewCounterVec(opts CounterOpts, esOpts CounterEsOpts, labelNames []string)
         labelNames,
         opts.ConstLabels,
     )
-    url := BuildEsUrl(esOpts.Host, esOpts.Port, esOpts.EsIndex, esOpts.EsType)
+    url := indexURL(EsOpts(esOpts))
     cv := CounterVec{
-        metricVec: newMetricVec(desc, url, func(lvs ...string) Metric {
+        metricVec: newMetricVec(desc, url, EsOpts(esOpts), func(lvs ...string) Metric {
             if len(lvs) != len(desc.variableLabels) {
                 panic(makeInconsistentCardinalityError(desc.fqName, desc.variableLabels, lvs))
             }
@@ -148,21 +156,52 @@ func NewCounterVec(opts CounterOpts, esOpts CounterEsOpts, labelNames []string)
             return result
         }),
     }
-    go cv.monitor(esOpts.Interval, desc.fqName)
+    if esOpts.Interval > 0 {
+        go cv.monitor(esOpts.Interval, desc.fqName)
+    }
     return &cv
 }
 
 func (v *CounterVec) monitor(second int, fqName string) {
     counterType := 1
     ticker := time.NewTicker(time.Duration(second)*time.Second)
-    counterLog := SetLog(fqName + WARN)
+    counterLog := newLogger(fqName, v.metricVec.metricMap.esOpts)
+    defer ticker.Stop()
     for {
-        <-ticker.C
+        select {
+        case <-v.metricVec.metricMap.stop:
+            return
+        case <-ticker.C:
+        }
         //1 is counter metric.
         v.metricVec.metricMap.pushDocToEs(counterType, counterLog)
     }
 }
 
+// ResetAndPush pushes the current values of all counters in this vector and
+// then deletes them, like Reset. Use it to keep the final values, e.g. at the
+// end of a processing phase. Cancelling ctx aborts the push.
+func (v *CounterVec) ResetAndPush(ctx context.Context) {
+    v.metricVec.metricMap.resetAndPush(ctx, COUNTER_TYPE)
+}
+
+// PushContext pushes the current values of all counters in this vector right
+// away, in addition to the periodic pushes. If any document could not be
+// written, a *PushError is returned. The documents are sent synchronously,
+// also if EsOpts.Async is set. Cancelling ctx aborts the push, e.g. on
+// shutdown, and the returned error then wraps ctx.Err().
+func (v *CounterVec) PushContext(ctx context.Context) error {
+    return v.metricVec.metricMap.pushContext(ctx, COUNTER_TYPE)
+}
+
+func (v *CounterVec) metricType() int {
+    return COUNTER_TYPE
+}
+
+func (v *CounterVec) push() {
+    v.metricVec.metricMap.pushDocToEs(COUNTER_TYPE, v.metricVec.metricMap.logger())
+}
+
 
 // GetMetricWithLabelValues returns the Counter for the given slice of label
 // values (same order as the VariableLabels in Desc). If that combination of
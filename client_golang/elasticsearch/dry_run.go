@@ -0,0 +1,48 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "bytes"
+    "fmt"
+)
+
+// offline reports whether the documents are not sent to the cluster, so that
+// no other requests must be sent to it either. See EsOpts.DryRun and
+// EsOpts.NDJSONWriter.
+func (m *metricMap) offline() bool {
+    return m.esOpts.DryRun || m.esOpts.NDJSONWriter != nil
+}
+
+// dryRun writes each document of batch, once per index URL, to
+// EsOpts.DryRunWriter or, if that is not set, logs it. See EsOpts.DryRun.
+func (m *metricMap) dryRun(batch *docBatch) {
+    w := m.esOpts.DryRunWriter
+    var buf bytes.Buffer
+    for _, doc := range batch.docs {
+        for _, u := range batch.urls {
+            if w == nil {
+                batch.log.Warnf("dry run, not pushing to %s: %s", m.docURL(u, doc), m.logBody(doc.data))
+                continue
+            }
+            fmt.Fprintf(&buf, "%s %s\n", m.docURL(u, doc), doc.data)
+        }
+    }
+    if w == nil {
+        return
+    }
+    if _, err := w.Write(buf.Bytes()); err != nil {
+        batch.log.Errorf("writing dry run of %s: %v", m.desc.fqName, err)
+    }
+}
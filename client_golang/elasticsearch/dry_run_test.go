@@ -0,0 +1,58 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestDryRun(t *testing.T) {
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        t.Errorf("unexpected request %s %s in dry run", r.Method, r.URL.Path)
+    })
+    defer server.Close()
+
+    var buf bytes.Buffer
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        DryRun:         true,
+        DryRunWriter:   &buf,
+        IdLabel:        "host",
+        ClusterName:    "prod",
+        PingBeforePush: true,
+    }, "host")
+    vec.WithLabelValues("dry1").Inc()
+    if err := vec.pushDocToEs(COUNTER_TYPE, DiscardLogger); err != nil {
+        t.Fatal(err)
+    }
+
+    lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+    if len(lines) != 1 {
+        t.Fatalf("got %d lines, want 1: %q", len(lines), buf.String())
+    }
+    fields := strings.SplitN(lines[0], " ", 2)
+    if want := server.URL + "/metrics/doc/dry1"; fields[0] != want {
+        t.Errorf("got URL %q, want %q", fields[0], want)
+    }
+    doc := map[string]interface{}{}
+    if err := json.Unmarshal([]byte(fields[1]), &doc); err != nil {
+        t.Fatal(err)
+    }
+    if doc["host"] != "dry1" || doc[TYPE] != METRIC_COUNTER || doc[VALUE] != 1.0 {
+        t.Errorf("unexpected document %v", doc)
+    }
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+// errorSampler logs a sample of the failures of a push as configured by
+// EsOpts.ErrorLogFirst and EsOpts.ErrorLogEvery.
+type errorSampler struct {
+    first, every int
+    log          Logger
+
+    failures   int
+    suppressed int
+}
+
+func (m *metricMap) newErrorSampler(log Logger) *errorSampler {
+    return &errorSampler{first: m.esOpts.ErrorLogFirst, every: m.esOpts.ErrorLogEvery, log: log}
+}
+
+// warn logs err if it is sampled.
+func (s *errorSampler) warn(err error) {
+    s.failures++
+    if s.sampled() {
+        s.log.Warn(err)
+        return
+    }
+    s.suppressed++
+}
+
+func (s *errorSampler) sampled() bool {
+    if s.first <= 0 && s.every <= 0 {
+        return true
+    }
+    if s.failures <= s.first {
+        return true
+    }
+    return s.every > 0 && (s.failures-s.first)%s.every == 0
+}
+
+// summarize logs the number of failures not logged, if any.
+func (s *errorSampler) summarize(fqName string) {
+    if s.suppressed > 0 {
+        s.log.Warnf(
+            "push of %s: %d of %d failures not logged", fqName, s.suppressed, s.failures,
+        )
+    }
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+)
+
+func TestErrorSampler(t *testing.T) {
+    scenarios := []struct {
+        first, every int
+        want         []int // Failures logged, 1-based.
+    }{
+        {want: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+        {first: 3, want: []int{1, 2, 3}},
+        {first: 2, every: 3, want: []int{1, 2, 5, 8}},
+        {every: 4, want: []int{4, 8}},
+    }
+    for _, s := range scenarios {
+        sampler := &errorSampler{first: s.first, every: s.every, log: DiscardLogger}
+        var got []int
+        for i := 1; i <= 10; i++ {
+            suppressed := sampler.suppressed
+            sampler.warn(errors.New("failed"))
+            if sampler.suppressed == suppressed {
+                got = append(got, i)
+            }
+        }
+        if !reflect.DeepEqual(got, s.want) {
+            t.Errorf("first %d, every %d: got failures %v logged, want %v", s.first, s.every, got, s.want)
+        }
+        if sampler.suppressed != 10-len(s.want) {
+            t.Errorf("first %d, every %d: got %d failures suppressed, want %d", s.first, s.every, sampler.suppressed, 10-len(s.want))
+        }
+    }
+}
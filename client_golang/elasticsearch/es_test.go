@@ -0,0 +1,1624 @@
+// Copyright 2014 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "math"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "reflect"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/golang/protobuf/proto"
+
+    dto "github.com/Schneizelw/elasticsearch/client_model/go"
+)
+
+// newTestCounterVec returns a CounterVec that is not monitored, i.e. pushes
+// only happen when the test calls pushDocToEs explicitly.
+func newTestCounterVec(url string, esOpts EsOpts, labelNames ...string) *CounterVec {
+    desc := NewDesc("test_counter", "helpless", labelNames, nil)
+    return &CounterVec{newMetricVec(desc, url, esOpts, func(lvs ...string) Metric {
+        result := &counter{desc: desc, labelPairs: makeLabelPairs(desc, lvs)}
+        result.init(result)
+        return result
+    })}
+}
+
+func TestDocID(t *testing.T) {
+    vec := newTestCounterVec("", EsOpts{IdLabel: "device_id"}, "device_id", "zone")
+
+    if got, want := vec.docID([]string{"dev/1", "eu"}), "dev%2F1"; got != want {
+        t.Errorf("got _id %q, want %q", got, want)
+    }
+    if got := vec.docID([]string{"", "eu"}); got == "" {
+        t.Error("expected time-based _id for empty label value")
+    }
+
+    vec = newTestCounterVec("", EsOpts{}, "device_id")
+    if got := vec.docID([]string{"dev1"}); got == "dev1" {
+        t.Error("label value used as _id although IdLabel is unset")
+    }
+}
+
+func TestGeneratedDocIDsUnique(t *testing.T) {
+    const n = 1000
+    var buf bytes.Buffer
+    vec := newTestCounterVec("http://localhost:9200/metrics/doc/", EsOpts{NDJSONWriter: &buf}, "series")
+    for i := 0; i < n; i++ {
+        vec.WithLabelValues(strconv.Itoa(i)).Inc()
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    ids := map[string]bool{}
+    for i, line := range bulkLines(buf.Bytes()) {
+        if i%2 != 0 {
+            continue
+        }
+        var action map[string]bulkMeta
+        if err := json.Unmarshal(line, &action); err != nil {
+            t.Fatal(err)
+        }
+        id := action["index"].ID
+        if ids[id] {
+            t.Fatalf("duplicate _id %q", id)
+        }
+        ids[id] = true
+    }
+    if len(ids) != 2*n {
+        t.Errorf("got %d _ids, want %d", len(ids), 2*n)
+    }
+}
+
+func TestPushAllowed(t *testing.T) {
+    vec := newTestCounterVec("", EsOpts{MinPushInterval: time.Minute})
+    now := time.Now()
+
+    if !vec.pushAllowed(now) {
+        t.Error("first push was not allowed")
+    }
+    if vec.pushAllowed(now.Add(30 * time.Second)) {
+        t.Error("push within MinPushInterval was allowed")
+    }
+    if !vec.pushAllowed(now.Add(time.Minute)) {
+        t.Error("push after MinPushInterval was not allowed")
+    }
+
+    vec = newTestCounterVec("", EsOpts{})
+    if !vec.pushAllowed(now) || !vec.pushAllowed(now) {
+        t.Error("push not allowed although MinPushInterval is unset")
+    }
+}
+
+// testRequest is a request received by a testServer.
+type testRequest struct {
+    method, path, query string
+    body                []byte
+}
+
+// testServer is a fake Elasticsearch node answering every request with
+// success and recording the requests received.
+type testServer struct {
+    *httptest.Server
+
+    mtx      sync.Mutex
+    requests []testRequest
+}
+
+// startServer starts an httptest.Server with keep-alives disabled so that no
+// idle connections (and their goroutines) outlive the test.
+func startServer(handler http.HandlerFunc) *httptest.Server {
+    server := httptest.NewUnstartedServer(handler)
+    server.Config.SetKeepAlivesEnabled(false)
+    server.Start()
+    return server
+}
+
+// newTestServer starts a testServer.
+func newTestServer() *testServer {
+    s := &testServer{}
+    s.Server = startServer(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := ioutil.ReadAll(r.Body)
+        s.mtx.Lock()
+        s.requests = append(s.requests, testRequest{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, body: body})
+        s.mtx.Unlock()
+        if strings.HasSuffix(r.URL.Path, "/_bulk") {
+            var items []map[string]bulkItem
+            for i, line := range bulkLines(body) {
+                var action map[string]bulkMeta
+                if i%2 == 1 || json.Unmarshal(line, &action) != nil {
+                    continue
+                }
+                for name, meta := range action {
+                    ack := WriteAck{Index: meta.Index, ID: meta.ID, Version: 1}
+                    items = append(items, map[string]bulkItem{name: {WriteAck: ack, Status: 201}})
+                }
+            }
+            json.NewEncoder(w).Encode(map[string]interface{}{"errors": false, "items": items})
+        }
+    })
+    return s
+}
+
+// bulkLines splits the NDJSON body of a bulk request into its lines.
+func bulkLines(body []byte) [][]byte {
+    return bytes.Split(bytes.TrimSuffix(body, []byte("\n")), []byte("\n"))
+}
+
+// sources returns the documents of all requests received so far, i.e. the
+// bodies of PUT requests and the source lines of bulk requests.
+func (s *testServer) sources() [][]byte {
+    s.mtx.Lock()
+    defer s.mtx.Unlock()
+
+    var sources [][]byte
+    for _, r := range s.requests {
+        if !strings.HasSuffix(r.path, "/_bulk") {
+            sources = append(sources, r.body)
+            continue
+        }
+        for i, line := range bulkLines(r.body) {
+            if i%2 == 1 {
+                sources = append(sources, line)
+            }
+        }
+    }
+    return sources
+}
+
+// docs returns the documents of all requests received so far, decoded.
+func (s *testServer) docs(t *testing.T) []map[string]interface{} {
+    sources := s.sources()
+    docs := make([]map[string]interface{}, 0, len(sources))
+    for _, source := range sources {
+        doc := map[string]interface{}{}
+        if err := json.Unmarshal(source, &doc); err != nil {
+            t.Fatalf("document %q is not a JSON object: %s", source, err)
+        }
+        docs = append(docs, doc)
+    }
+    return docs
+}
+
+func TestDocumentValidator(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        DocumentValidator: func(doc map[string]interface{}) error {
+            if doc["user"] == "secret" {
+                return errors.New("PII detected")
+            }
+            return nil
+        },
+    }, "user")
+    vec.WithLabelValues("alice").Inc()
+    vec.WithLabelValues("secret").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    if got := len(server.docs(t)); got != 1 {
+        t.Errorf("got %d documents, want 1", got)
+    }
+    m := &dto.Metric{}
+    vec.pushMetrics.droppedDocs.Write(m)
+    if got := m.GetCounter().GetValue(); got != 1 {
+        t.Errorf("got %v dropped documents, want 1", got)
+    }
+}
+
+func TestSummaryLongFormat(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    desc := NewDesc("test_summary", "helpless", []string{"a"}, nil)
+    opts := SummaryOpts{Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01}}
+    vec := &SummaryVec{newMetricVec(desc, server.URL+"/metrics/doc/", EsOpts{SummaryLongFormat: true}, func(lvs ...string) Metric {
+        return newSummary(desc, opts, lvs...)
+    })}
+    vec.WithLabelValues("x").Observe(1)
+    vec.pushDocToEs(SUMMARY_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 2 {
+        t.Fatalf("got %d documents, want one per quantile", len(docs))
+    }
+    for _, doc := range docs {
+        if _, ok := doc[QUANTILE]; !ok {
+            t.Errorf("document %v lacks the %s field", doc, QUANTILE)
+        }
+        if doc[VALUE] != 1. {
+            t.Errorf("got value %v, want 1", doc[VALUE])
+        }
+        if _, ok := doc[QUANTILE_50]; ok {
+            t.Errorf("document %v carries wide-format fields", doc)
+        }
+    }
+}
+
+func newTestHistogramVec(url string, esOpts EsOpts, buckets []float64, labelNames ...string) *HistogramVec {
+    desc := NewDesc("test_histogram", "helpless", labelNames, nil)
+    opts := HistogramOpts{Buckets: buckets}
+    return &HistogramVec{newMetricVec(desc, url, esOpts, func(lvs ...string) Metric {
+        return newHistogram(desc, opts, lvs...)
+    })}
+}
+
+func TestHistogramLongFormat(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestHistogramVec(server.URL+"/metrics/doc/", EsOpts{HistogramLongFormat: true}, []float64{1, 2})
+    vec.WithLabelValues().Observe(1.5)
+    vec.pushDocToEs(HISTOGRAM_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 2 {
+        t.Fatalf("got %d documents, want one per bucket", len(docs))
+    }
+    counts := map[float64]float64{}
+    for _, doc := range docs {
+        if _, ok := doc[BUCKETS]; ok {
+            t.Errorf("document %v carries the nested %s array", doc, BUCKETS)
+        }
+        counts[doc[LE].(float64)] = doc[COUNT].(float64)
+    }
+    if counts[1] != 0 || counts[2] != 1 {
+        t.Errorf("got bucket counts %v, want 0 for le=1 and 1 for le=2", counts)
+    }
+}
+
+func TestMarshalErrorPolicy(t *testing.T) {
+    for _, policy := range []MarshalErrorPolicy{DiscardAndCount, DiscardAndLog, FailCycle} {
+        server := newTestServer()
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+            MarshalErrorPolicy: policy,
+            // Make every other document unmarshalable.
+            DocumentValidator: func(doc map[string]interface{}) error {
+                delete(doc, "bad")
+                if doc["a"] == "1" || doc["a"] == "3" {
+                    doc["bad"] = func() {}
+                }
+                return nil
+            },
+        }, "a")
+        for _, lv := range []string{"1", "2", "3", "4"} {
+            vec.WithLabelValues(lv).Inc()
+        }
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+        server.Close()
+
+        m := &dto.Metric{}
+        vec.pushMetrics.marshalErrors.Write(m)
+        docs := len(server.docs(t))
+        if policy == FailCycle {
+            if got := m.GetCounter().GetValue(); got != 1 {
+                t.Errorf("policy %d: got %v marshal errors, want 1", policy, got)
+            }
+            if docs > 2 {
+                t.Errorf("policy %d: got %d documents after aborted cycle", policy, docs)
+            }
+            continue
+        }
+        if got := m.GetCounter().GetValue(); got != 2 {
+            t.Errorf("policy %d: got %v marshal errors, want 2", policy, got)
+        }
+        if docs != 2 {
+            t.Errorf("policy %d: got %d documents, want 2", policy, docs)
+        }
+    }
+}
+
+func TestFanOut(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    u, err := url.Parse(server.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    esOpts := EsOpts{
+        Host:    u.Hostname(),
+        Port:    u.Port(),
+        EsIndex: "hires",
+        EsType:  "doc",
+        FanOut:  []FanOutIndex{{Index: "lores", Every: 2}},
+
+        PerDocument: true,
+    }
+    vec := newTestCounterVec(BuildEsUrl(esOpts.Host, esOpts.Port, esOpts.EsIndex, esOpts.EsType), esOpts)
+    vec.WithLabelValues().Inc()
+    for i := 0; i < 3; i++ {
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    }
+
+    indices := map[string]int{}
+    for _, r := range server.requests {
+        indices[strings.Split(r.path, "/")[1]]++
+    }
+    if indices["hires"] != 3 || indices["lores"] != 2 {
+        t.Errorf("got documents per index %v, want 3 for hires and 2 for lores", indices)
+    }
+}
+
+func TestGaugeAggregates(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    desc := NewDesc("test_gauge", "helpless", nil, nil)
+    vec := &GaugeVec{newMetricVec(desc, server.URL+"/metrics/doc/", EsOpts{}, func(lvs ...string) Metric {
+        result := &gauge{desc: desc, agg: &gaugeAggregates{}}
+        result.init(result)
+        return result
+    })}
+    g := vec.WithLabelValues()
+    g.Set(4)
+    g.Add(-3)
+    g.Set(5)
+    vec.pushDocToEs(GAUGE_TYPE, DiscardLogger)
+    vec.pushDocToEs(GAUGE_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 2 {
+        t.Fatalf("got %d documents, want 2", len(docs))
+    }
+    for i, want := range []map[string]float64{
+        {MIN: 1, MAX: 5, AVG: 10. / 3, LAST: 5},
+        // No updates in between, so all aggregates are the last value.
+        {MIN: 5, MAX: 5, AVG: 5, LAST: 5},
+    } {
+        for field, v := range want {
+            if got := docs[i][field]; got != v {
+                t.Errorf("push %d: got %s %v, want %v", i, field, got, v)
+            }
+        }
+    }
+}
+
+func TestBatchTimeout(t *testing.T) {
+    var requests int32
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&requests, 1)
+        time.Sleep(50 * time.Millisecond)
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        BatchTimeout: 75 * time.Millisecond,
+        PerDocument:  true,
+    }, "a")
+    for _, lv := range []string{"1", "2", "3", "4", "5"} {
+        vec.WithLabelValues(lv).Inc()
+    }
+    start := time.Now()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+        t.Errorf("push took %v despite BatchTimeout", elapsed)
+    }
+    if got := atomic.LoadInt32(&requests); got != 2 {
+        t.Errorf("got %d requests, want 2", got)
+    }
+}
+
+func TestPushSetupHonoursContext(t *testing.T) {
+    release := make(chan struct{})
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        // A cluster hanging in all requests before the documents.
+        if r.URL.Path != "/_bulk" {
+            <-release
+        }
+    })
+    defer server.Close()
+    defer close(release)
+
+    for _, esOpts := range []EsOpts{
+        {ClusterName: "prod"},
+        {PingBeforePush: true},
+        {IndexTemplate: &IndexTemplate{Name: "metrics"}},
+        {SchemaStrict: true},
+    } {
+        esOpts.BatchTimeout = 50 * time.Millisecond
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", esOpts)
+        vec.log = DiscardLogger
+        vec.WithLabelValues().Inc()
+
+        start := time.Now()
+        if err := vec.pushDocToEs(COUNTER_TYPE, DiscardLogger); err == nil {
+            t.Errorf("%+v: expected an error", esOpts)
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+        vec.esOpts.BatchTimeout = 0
+        if err := vec.PushContext(ctx); err == nil {
+            t.Errorf("%+v: expected an error", esOpts)
+        }
+        cancel()
+        if elapsed := time.Since(start); elapsed > time.Second {
+            t.Errorf("%+v: pushes took %v despite their deadlines", esOpts, elapsed)
+        }
+    }
+}
+
+func TestCredentialsProvider(t *testing.T) {
+    var (
+        mtx  sync.Mutex
+        auth []string
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        auth = append(auth, r.Header.Get("Authorization"))
+        mtx.Unlock()
+    })
+    defer server.Close()
+
+    token := 0
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        Credentials: "Bearer static",
+        CredentialsProvider: func() (string, error) {
+            token++
+            return "Bearer " + strconv.Itoa(token), nil
+        },
+    })
+    vec.WithLabelValues().Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    if want := []string{"Bearer 1", "Bearer 2"}; !reflect.DeepEqual(auth, want) {
+        t.Errorf("got Authorization headers %q, want %q", auth, want)
+    }
+}
+
+func TestSetLocation(t *testing.T) {
+    geo := &GeoPointLabels{LatLabel: "lat", LonLabel: "lon"}
+
+    docMap := map[string]interface{}{}
+    if !setLocation(docMap, map[string]string{"lat": "52.52", "lon": "13.405", "device": "d1"}, geo) {
+        t.Error("location not set")
+    }
+    want := map[string]interface{}{
+        LOCATION: map[string]float64{"lat": 52.52, "lon": 13.405},
+    }
+    if !reflect.DeepEqual(docMap, want) {
+        t.Errorf("got %v, want %v", docMap, want)
+    }
+
+    for _, labels := range []map[string]string{
+        {"lat": "unknown", "lon": "13.405"},
+        {"lat": "NaN", "lon": "13.405"},
+        {"lat": "52.52", "lon": "+Inf"},
+        {"lat": "-Inf", "lon": "13.405"},
+        {"lat": "90.5", "lon": "13.405"},
+        {"lat": "52.52", "lon": "-180.5"},
+    } {
+        docMap = map[string]interface{}{}
+        if setLocation(docMap, labels, geo) {
+            t.Errorf("location set for invalid coordinates %v", labels)
+        }
+        if _, ok := docMap[LOCATION]; ok {
+            t.Errorf("got %v for %v, want no location", docMap, labels)
+        }
+    }
+
+    docMap = map[string]interface{}{}
+    if !setLocation(docMap, map[string]string{"lat": "-90", "lon": "180"}, geo) {
+        t.Error("location not set for coordinates at the bounds")
+    }
+}
+
+func TestGeoPointDropLabels(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        GeoPoint: &GeoPointLabels{LatLabel: "lat", LonLabel: "lon", DropLabels: true},
+    }, "lat", "lon", "device")
+    vec.WithLabelValues("52.52", "13.405", "d1").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    _, lat := docs[0]["lat"]
+    _, lon := docs[0]["lon"]
+    if lat || lon || docs[0]["device"] != "d1" || docs[0][LOCATION] == nil {
+        t.Errorf("got document %v, want the location instead of the coordinate labels", docs[0])
+    }
+}
+
+func TestFieldPrefix(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{FieldPrefix: "metric_"}, "code")
+    vec.WithLabelValues("200").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    for k := range docs[0] {
+        if !strings.HasPrefix(k, "metric_") {
+            t.Errorf("field %q lacks the prefix", k)
+        }
+    }
+    if docs[0]["metric_code"] != "200" || docs[0]["metric_"+VALUE] != 1. {
+        t.Errorf("unexpected document %v", docs[0])
+    }
+}
+
+func TestFqNamePrefix(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        FqNamePrefix:        "orderservice_",
+        CardinalityDocument: true,
+    })
+    vec.WithLabelValues().Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 2 {
+        t.Fatalf("got %d documents, want the counter and the cardinality document", len(docs))
+    }
+    for _, doc := range docs {
+        if doc[FQNAME] != "orderservice_test_counter" {
+            t.Errorf("got %s %v, want orderservice_test_counter", FQNAME, doc[FQNAME])
+        }
+    }
+    if vec.desc.fqName != "test_counter" {
+        t.Errorf("got metric name %q, want it unchanged", vec.desc.fqName)
+    }
+}
+
+func TestNewUUID(t *testing.T) {
+    re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+    if !re.MatchString(instanceUUID) {
+        t.Errorf("%q is not a version 4 UUID", instanceUUID)
+    }
+    if newUUID() == instanceUUID {
+        t.Error("UUIDs are not random")
+    }
+}
+
+func TestPushFilter(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        PushFilter: func(m dto.Metric) bool { return m.GetCounter().GetValue() > 1 },
+    }, "code")
+    vec.WithLabelValues("200").Add(5)
+    vec.WithLabelValues("500").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    if docs[0]["code"] != "200" {
+        t.Errorf("unexpected document %v", docs[0])
+    }
+}
+
+func TestLabelValueMapper(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    categories := map[string]string{"200": "success"}
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        LabelValueMapper: func(labelName, value string) string {
+            if c, ok := categories[value]; ok && labelName == "code" {
+                return c
+            }
+            return value
+        },
+    }, "code", "zone")
+    vec.WithLabelValues("200", "200").Inc()
+    vec.WithLabelValues("500", "eu").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    got := map[string]string{}
+    for _, doc := range server.docs(t) {
+        got[doc["code"].(string)] = doc["zone"].(string)
+    }
+    if want := map[string]string{"success": "200", "500": "eu"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("got codes and zones %v, want %v", got, want)
+    }
+}
+
+func TestHeaders(t *testing.T) {
+    var (
+        mtx    sync.Mutex
+        header http.Header
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        header = r.Header
+        mtx.Unlock()
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        Credentials: "Bearer static",
+        Headers: map[string]string{
+            "X-Opaque-Id":   "metrics",
+            "Authorization": "Bearer other",
+        },
+    })
+    vec.WithLabelValues().Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    mtx.Lock()
+    defer mtx.Unlock()
+    if got := header.Get("X-Opaque-Id"); got != "metrics" {
+        t.Errorf("got X-Opaque-Id header %q, want %q", got, "metrics")
+    }
+    if got := header.Get("Authorization"); got != "Bearer static" {
+        t.Errorf("got Authorization header %q, want %q", got, "Bearer static")
+    }
+}
+
+func TestSetSampleTimes(t *testing.T) {
+    esOpts := EsOpts{EventTimeField: "event_time", IngestTimeField: "ingest_time"}
+    collected := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+    docMap := map[string]interface{}{}
+    setSampleTimes(docMap, dto.Metric{}, collected, esOpts)
+    if got, want := docMap["event_time"], "2019-06-01T12:00:00.000Z"; got != want {
+        t.Errorf("got event time %v, want %v", got, want)
+    }
+    ingestTime, err := time.Parse(time.RFC3339, docMap["ingest_time"].(string))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !ingestTime.After(collected) {
+        t.Errorf("ingest time %v is not after collection time %v", ingestTime, collected)
+    }
+
+    docMap = map[string]interface{}{}
+    setSampleTimes(docMap, dto.Metric{TimestampMs: proto.Int64(1559390400123)}, collected, esOpts)
+    if got, want := docMap["event_time"], "2019-06-01T12:00:00.123Z"; got != want {
+        t.Errorf("got event time %v, want %v", got, want)
+    }
+
+    docMap = map[string]interface{}{}
+    setSampleTimes(docMap, dto.Metric{}, collected, EsOpts{})
+    if len(docMap) != 0 {
+        t.Errorf("got fields %v, want none", docMap)
+    }
+}
+
+func TestResetAndPush(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{Async: true, MarkReset: true}, "phase")
+    vec.log = DiscardLogger
+    vec.WithLabelValues("load").Add(3)
+    vec.ResetAndPush(context.Background())
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    if docs[0]["phase"] != "load" || docs[0][VALUE] != 3. || docs[0][RESET] != true {
+        t.Errorf("unexpected document %v", docs[0])
+    }
+    if len(vec.metrics) != 0 {
+        t.Errorf("got %d metrics after reset, want 0", len(vec.metrics))
+    }
+
+    vec.ResetAndPush(context.Background())
+    if docs := server.docs(t); len(docs) != 1 {
+        t.Errorf("got %d documents after pushing an empty vector, want 1", len(docs))
+    }
+}
+
+func TestResetAndPushDoesNotBlock(t *testing.T) {
+    var (
+        verifying = make(chan struct{})
+        release   = make(chan struct{})
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/" {
+            close(verifying)
+            <-release
+            w.Write([]byte(`{"cluster_name":"prod"}`))
+            return
+        }
+        w.Write([]byte(`{"errors":false,"items":[]}`))
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{ClusterName: "prod"}, "phase")
+    vec.log = DiscardLogger
+    vec.WithLabelValues("load").Inc()
+    pushed := make(chan struct{})
+    go func() {
+        vec.ResetAndPush(context.Background())
+        close(pushed)
+    }()
+    <-verifying
+
+    created := make(chan struct{})
+    go func() {
+        vec.WithLabelValues("next").Inc()
+        close(created)
+    }()
+    select {
+    case <-created:
+    case <-time.After(time.Second):
+        t.Error("creating a metric blocked on the push")
+    }
+    close(release)
+    <-pushed
+    if got := collectedMetrics(vec); got != 1 {
+        t.Errorf("got %d metrics after the reset, want the one created meanwhile", got)
+    }
+}
+
+func TestInstanceLabel(t *testing.T) {
+    os.Setenv("TEST_POD_NAME", "pod-1")
+    defer os.Unsetenv("TEST_POD_NAME")
+
+    if got := instanceName("TEST_POD_NAME"); got != "pod-1" {
+        t.Errorf("got instance %q, want %q", got, "pod-1")
+    }
+    hostname, err := os.Hostname()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := instanceName("TEST_UNSET"); got != hostname {
+        t.Errorf("got instance %q, want hostname %q", got, hostname)
+    }
+
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{InstanceLabel: true, InstanceEnvVar: "TEST_POD_NAME"}, "job")
+    vec.WithLabelValues("batch").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    if docs[0][INSTANCE] != "pod-1" || docs[0]["job"] != "batch" {
+        t.Errorf("unexpected document %v", docs[0])
+    }
+}
+
+func TestIndexPerType(t *testing.T) {
+    vec := newTestCounterVec("http://localhost:9200/metrics/doc/", EsOpts{
+        IndexPerType: true,
+        TypeIndices:  map[int]string{HISTOGRAM_TYPE: "latencies"},
+    })
+    scenarios := map[int]string{
+        COUNTER_TYPE:   "http://localhost:9200/metrics-counter/doc/",
+        GAUGE_TYPE:     "http://localhost:9200/metrics-gauge/doc/",
+        SUMMARY_TYPE:   "http://localhost:9200/metrics-summary/doc/",
+        HISTOGRAM_TYPE: "http://localhost:9200/latencies/doc/",
+    }
+    for metricType, want := range scenarios {
+        got, err := vec.primaryURL(metricType, time.Now())
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got != want {
+            t.Errorf("got URL %q for type %d, want %q", got, metricType, want)
+        }
+    }
+
+    vec.esOpts.IndexPerType = false
+    if got, _ := vec.primaryURL(COUNTER_TYPE, time.Now()); got != vec.url {
+        t.Errorf("got URL %q, want %q", got, vec.url)
+    }
+}
+
+func TestIndexDatePattern(t *testing.T) {
+    vec := newTestCounterVec("http://localhost:9200/metrics/doc/", EsOpts{IndexDatePattern: "metrics-%Y.%m.%d"})
+    scenarios := map[time.Time]string{
+        time.Date(2024, 1, 15, 23, 59, 59, 0, time.UTC): "http://localhost:9200/metrics-2024.01.15/doc/",
+        time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC):    "http://localhost:9200/metrics-2024.01.16/doc/",
+    }
+    for now, want := range scenarios {
+        got, err := vec.primaryURL(COUNTER_TYPE, now)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got != want {
+            t.Errorf("got URL %q at %v, want %q", got, now, want)
+        }
+    }
+
+    vec.esOpts.IndexPerType = true
+    got, _ := vec.primaryURL(GAUGE_TYPE, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+    if want := "http://localhost:9200/metrics-2024.01.15-gauge/doc/"; got != want {
+        t.Errorf("got URL %q, want %q", got, want)
+    }
+
+    for _, pattern := range []string{"metrics-%j", "metrics-%"} {
+        if err := validateIndexDatePattern(EsOpts{IndexDatePattern: pattern}); err == nil {
+            t.Errorf("%s: expected an error", pattern)
+        }
+    }
+}
+
+func TestTombstones(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{Tombstones: true}, "session")
+    vec.log = DiscardLogger
+    vec.WithLabelValues("s1").Add(2)
+    vec.WithLabelValues("s2").Add(3)
+    if !vec.DeleteLabelValues("s1") || !vec.Delete(Labels{"session": "s2"}) {
+        t.Fatal("series not deleted")
+    }
+    if vec.DeleteLabelValues("s1") {
+        t.Fatal("series deleted twice")
+    }
+
+    docs := server.docs(t)
+    if len(docs) != 2 {
+        t.Fatalf("got %d documents, want 2", len(docs))
+    }
+    for i, want := range []struct {
+        session string
+        value   float64
+    }{{"s1", 2}, {"s2", 3}} {
+        if docs[i]["session"] != want.session || docs[i][VALUE] != want.value || docs[i][DELETED_AT] == nil {
+            t.Errorf("unexpected document %v", docs[i])
+        }
+    }
+    if len(vec.metrics) != 0 {
+        t.Errorf("got %d metrics after deletion, want 0", len(vec.metrics))
+    }
+}
+
+func TestTombstoneDelta(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{Tombstones: true}, "session")
+    vec.log = DiscardLogger
+    for i := 0; i < 50; i++ {
+        vec.WithLabelValues(strconv.Itoa(i)).Add(7)
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    for i := 0; i < 50; i++ {
+        vec.WithLabelValues(strconv.Itoa(i)).Add(3)
+        if !vec.DeleteLabelValues(strconv.Itoa(i)) {
+            t.Fatal("series not deleted")
+        }
+    }
+
+    if got := vec.lastValues.len(); got != 0 {
+        t.Errorf("got %d last values after deleting all series, want 0", got)
+    }
+    if got := atomic.LoadUint64(&vec.cycles); got != 1 {
+        t.Errorf("got %d push cycles, want the tombstones not to count", got)
+    }
+    var tombstones int
+    for _, doc := range server.docs(t) {
+        if doc[DELETED_AT] == nil {
+            continue
+        }
+        tombstones++
+        if doc[VALUE] != 3. {
+            t.Errorf("got tombstone %v, want the value not pushed yet, 3", doc)
+        }
+    }
+    if tombstones != 50 {
+        t.Errorf("got %d tombstones, want 50", tombstones)
+    }
+}
+
+func TestCardinalityDocument(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{CardinalityDocument: true}, "path")
+    for _, path := range []string{"/a", "/b", "/c"} {
+        vec.WithLabelValues(path).Inc()
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    var meta []map[string]interface{}
+    for _, doc := range server.docs(t) {
+        if doc[TYPE] == METRIC_CARDINALITY {
+            meta = append(meta, doc)
+        }
+    }
+    if len(meta) != 1 {
+        t.Fatalf("got %d meta-documents, want 1", len(meta))
+    }
+    if meta[0][FQNAME] != "test_counter" || meta[0][CARDINALITY] != 3. {
+        t.Errorf("unexpected meta-document %v", meta[0])
+    }
+}
+
+func TestEnvelopeNested(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{EnvelopeVersion: EnvelopeNested}, "env_code")
+    vec.WithLabelValues("nested").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    labels, ok := docs[0][LABELS].(map[string]interface{})
+    if !ok || labels["env_code"] != "nested" {
+        t.Errorf("got labels %v, want env_code nested", docs[0][LABELS])
+    }
+    if _, ok := docs[0]["env_code"]; ok || docs[0][VALUE] != 1. {
+        t.Errorf("unexpected document %v", docs[0])
+    }
+    if got := vec.expectedMapping(COUNTER_TYPE)["Labels.env_code"]; got != "keyword" {
+        t.Errorf("got expected mapping %q for the nested label, want keyword", got)
+    }
+}
+
+func TestEnvelopeNestedReservedLabels(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{EnvelopeVersion: EnvelopeNested}, VALUE, TYPE)
+    vec.WithLabelValues("42", "request").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    want := map[string]interface{}{VALUE: "42", TYPE: "request"}
+    if labels := docs[0][LABELS]; !reflect.DeepEqual(labels, want) {
+        t.Errorf("got labels %v, want %v", labels, want)
+    }
+    if docs[0][VALUE] != 1. || docs[0][TYPE] != "Counter" {
+        t.Errorf("got document %v, want the metric fields at the top level", docs[0])
+    }
+}
+
+func TestCounterFloatTolerance(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{CounterFloatTolerance: 1e-9}, "tolerance_code")
+    counter := vec.WithLabelValues("tolerance")
+    counter.Add(1)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    counter.Add(1e-12)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    counter.Add(2)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 3 {
+        t.Fatalf("got %d documents, want 3", len(docs))
+    }
+    for i, want := range []float64{1, 0, 2 + 1e-12} {
+        if got := docs[i][VALUE].(float64); math.Abs(got-want) > 1e-15 {
+            t.Errorf("push %d: got value %v, want %v", i, got, want)
+        }
+    }
+}
+
+func TestCounterMode(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    for mode, want := range map[CounterMode][]float64{
+        CounterDelta:      {10, 20},
+        CounterCumulative: {10, 30},
+    } {
+        server.mtx.Lock()
+        server.requests = nil
+        server.mtx.Unlock()
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{CounterMode: mode}, "mode_code")
+        counter := vec.WithLabelValues("mode")
+        counter.Add(10)
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+        counter.Add(20)
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+        docs := server.docs(t)
+        if len(docs) != 2 {
+            t.Fatalf("mode %d: got %d documents, want 2", mode, len(docs))
+        }
+        for i := range docs {
+            if got := docs[i][VALUE]; got != want[i] {
+                t.Errorf("mode %d, push %d: got value %v, want %v", mode, i, got, want[i])
+            }
+        }
+        if mode == CounterCumulative && vec.lastValues.len() != 0 {
+            t.Errorf("got %d last values in cumulative mode, want 0", vec.lastValues.len())
+        }
+    }
+}
+
+func TestLastValueTTL(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{LastValueTTL: time.Hour}, "ttl_code")
+    vec.WithLabelValues("ttl").Inc()
+    vec.lastValues.delta(12345, 1, 0, time.Now().Add(-2*time.Hour))
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    vec.lastValues.mtx.Lock()
+    _, ok := vec.lastValues.values[12345]
+    vec.lastValues.mtx.Unlock()
+    if ok {
+        t.Error("stale last value was not pruned")
+    }
+    if docs := server.docs(t); len(docs) != 1 || docs[0][VALUE] != 1. {
+        t.Errorf("got documents %v, want one with value 1", docs)
+    }
+}
+
+func TestDeletePrunesLastValues(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "prune_code")
+    lastValue := func(lvs ...string) bool {
+        h, err := vec.hashLabelValues(lvs)
+        if err != nil {
+            t.Fatal(err)
+        }
+        vec.lastValues.mtx.Lock()
+        defer vec.lastValues.mtx.Unlock()
+        _, ok := vec.lastValues.values[h]
+        return ok
+    }
+    push := func(lvs ...string) {
+        vec.WithLabelValues(lvs...).Inc()
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    }
+
+    push("prune_a")
+    push("prune_b")
+    if !lastValue("prune_a") || !lastValue("prune_b") {
+        t.Fatal("last values of pushed series are missing")
+    }
+    vec.DeleteLabelValues("prune_a")
+    vec.Delete(Labels{"prune_code": "prune_b"})
+    if lastValue("prune_a") || lastValue("prune_b") {
+        t.Error("last values of deleted series were not pruned")
+    }
+
+    push("prune_c")
+    vec.Reset()
+    if lastValue("prune_c") {
+        t.Error("last value was not pruned on Reset")
+    }
+}
+
+func TestValueScale(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestHistogramVec(server.URL+"/metrics/doc/", EsOpts{ValueScale: 1e-3}, []float64{1000}, "scale_code")
+    vec.WithLabelValues("scale").Observe(500)
+    vec.pushDocToEs(HISTOGRAM_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    if docs[0][SUM] != 0.5 || docs[0][COUNT] != 1. {
+        t.Errorf("got sum %v and count %v, want 0.5 and 1", docs[0][SUM], docs[0][COUNT])
+    }
+    buckets := docs[0][BUCKETS].([]interface{})
+    if le := buckets[0].(map[string]interface{})[LE]; le != 1. {
+        t.Errorf("got bucket upper bound %v, want 1", le)
+    }
+}
+
+func TestValueScaleUntyped(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := NewUntypedVec(UntypedOpts{Name: "test_untyped", Help: "helpless"}, UntypedEsOpts{
+        URL:        server.URL + "/metrics/doc/",
+        ValueScale: 1e-3,
+        Logger:     DiscardLogger,
+    }, []string{"scale_code"})
+    defer vec.Stop()
+    vec.WithLabelValues("scale").Set(1500)
+    if err := vec.PushContext(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    if docs[0][TYPE] != METRIC_UNTYPED || docs[0][VALUE] != 1.5 {
+        t.Errorf("got type %v and value %v, want %s and 1.5", docs[0][TYPE], docs[0][VALUE], METRIC_UNTYPED)
+    }
+}
+
+func TestPercentileHints(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestHistogramVec(server.URL+"/metrics/doc/", EsOpts{PercentileHints: []float64{50, 99.9}}, []float64{1}, "hint_code")
+    vec.WithLabelValues("hint").Observe(0.5)
+    vec.pushDocToEs(HISTOGRAM_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    want := []interface{}{50., 99.9}
+    if got := docs[0][PERCENTILE_HINTS]; !reflect.DeepEqual(got, want) {
+        t.Errorf("got percentile hints %v, want %v", got, want)
+    }
+}
+
+func TestMetricExpiry(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{MetricExpiry: 20 * time.Millisecond}, "expiry_code")
+    idle := vec.WithLabelValues("expiry_idle")
+    busy := vec.WithLabelValues("expiry_busy")
+    idle.Inc()
+    busy.Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    time.Sleep(30 * time.Millisecond)
+    busy.Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 3 {
+        t.Fatalf("got %d documents, want 3", len(docs))
+    }
+    if docs[2]["expiry_code"] != "expiry_busy" {
+        t.Errorf("got %v pushed after the expiry, want only expiry_busy", docs[2])
+    }
+    if n := collectedMetrics(vec); n != 2 {
+        t.Errorf("got %d collected series, want 2", n)
+    }
+}
+
+// collectedMetrics returns the number of metrics collected from c.
+func collectedMetrics(c Collector) int {
+    ch := make(chan Metric)
+    go func() {
+        c.Collect(ch)
+        close(ch)
+    }()
+    n := 0
+    for range ch {
+        n++
+    }
+    return n
+}
+
+func TestSampleCallback(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    var samples []string
+    vec := newTestHistogramVec(server.URL+"/metrics/doc/", EsOpts{
+        ValueScale: 2,
+        SampleCallback: func(fqName string, labels map[string]string, m dto.Metric) {
+            h := m.GetHistogram()
+            samples = append(samples, fmt.Sprintf(
+                "%s %s %d %g", fqName, labels["sample_code"], h.GetSampleCount(), h.GetSampleSum(),
+            ))
+            labels["sample_code"] = "modified"
+            h.SampleSum = proto.Float64(0)
+        },
+    }, []float64{1}, "sample_code")
+    vec.WithLabelValues("sample").Observe(0.25)
+    vec.pushDocToEs(HISTOGRAM_TYPE, DiscardLogger)
+
+    if want := []string{"test_histogram sample 1 0.25"}; !reflect.DeepEqual(samples, want) {
+        t.Errorf("got samples %q, want %q", samples, want)
+    }
+    docs := server.docs(t)
+    if len(docs) != 1 || docs[0]["sample_code"] != "sample" || docs[0][SUM] != 0.5 {
+        t.Errorf("got documents %v, want one unaffected by the callback", docs)
+    }
+}
+
+func TestExactIntegers(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{ExactIntegers: true}, "exact_code")
+    exact := vec.WithLabelValues("exact")
+    exact.Add(1 << 53)
+    exact.Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    exact.Add(2)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.WithLabelValues("inexact").Add(0.5)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    var values []string
+    for _, source := range server.sources() {
+        var doc map[string]json.RawMessage
+        if err := json.Unmarshal(source, &doc); err != nil {
+            t.Fatal(err)
+        }
+        if string(doc["exact_code"]) == `"exact"` {
+            values = append(values, string(doc[VALUE]))
+        }
+    }
+    if want := []string{"9007199254740993", "2", "0"}; !reflect.DeepEqual(values, want) {
+        t.Errorf("got values %q, want %q", values, want)
+    }
+}
+
+func TestSetMetricDataHistogram(t *testing.T) {
+    dtoMetric := dto.Metric{Histogram: &dto.Histogram{
+        SampleCount: proto.Uint64(6),
+        SampleSum:   proto.Float64(12.5),
+        Bucket: []*dto.Bucket{
+            {UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(2)},
+            {UpperBound: proto.Float64(5), CumulativeCount: proto.Uint64(5)},
+            {UpperBound: proto.Float64(math.Inf(+1)), CumulativeCount: proto.Uint64(6)},
+        },
+    }}
+    docMap := map[string]interface{}{}
+    setMetricData(HISTOGRAM_TYPE, dtoMetric, docMap)
+
+    want := map[string]interface{}{
+        TYPE:  METRIC_HISTOGRAM,
+        SUM:   12.5,
+        COUNT: uint64(6),
+        // The +Inf bucket is left out, its count is COUNT.
+        BUCKETS: []map[string]interface{}{
+            {LE: 1., COUNT: uint64(2)},
+            {LE: 5., COUNT: uint64(5)},
+        },
+    }
+    if !reflect.DeepEqual(docMap, want) {
+        t.Errorf("got %v, want %v", docMap, want)
+    }
+}
+
+func TestSetMetricDataUntyped(t *testing.T) {
+    dtoMetric := dto.Metric{Untyped: &dto.Untyped{Value: proto.Float64(42)}}
+    docMap := map[string]interface{}{}
+    setMetricData(UNTYPED_TYPE, dtoMetric, docMap)
+
+    want := map[string]interface{}{TYPE: METRIC_UNTYPED, VALUE: 42.}
+    if !reflect.DeepEqual(docMap, want) {
+        t.Errorf("got %v, want %v", docMap, want)
+    }
+
+    // Like gauges, untyped values are pushed as they are, not as deltas.
+    var buf bytes.Buffer
+    desc := NewDesc("test_untyped", "helpless", nil, nil)
+    vec := newMetricVec(desc, "http://localhost:9200/metrics/doc/", EsOpts{NDJSONWriter: &buf}, func(lvs ...string) Metric {
+        return MustNewConstMetric(desc, UntypedValue, 42, lvs...)
+    })
+    vec.getMetricWithLabelValues()
+    vec.pushDocToEs(UNTYPED_TYPE, DiscardLogger)
+    vec.pushDocToEs(UNTYPED_TYPE, DiscardLogger)
+    for i, line := range bulkLines(buf.Bytes()) {
+        if i%2 == 0 {
+            continue
+        }
+        doc := map[string]interface{}{}
+        if err := json.Unmarshal(line, &doc); err != nil {
+            t.Fatal(err)
+        }
+        if doc[TYPE] != METRIC_UNTYPED || doc[VALUE] != 42. {
+            t.Errorf("unexpected document %v", doc)
+        }
+    }
+}
+
+func TestQuantileField(t *testing.T) {
+    scenarios := map[float64]string{
+        0:     "QUANTILE_0",
+        0.05:  "QUANTILE_5",
+        0.29:  "QUANTILE_29",
+        0.5:   "QUANTILE_50",
+        0.75:  "QUANTILE_75",
+        0.9:   "QUANTILE_90",
+        0.95:  "QUANTILE_95",
+        0.99:  "QUANTILE_99",
+        0.999: "QUANTILE_99_9",
+        1:     "QUANTILE_100",
+    }
+    for q, want := range scenarios {
+        if got := quantileField(q); got != want {
+            t.Errorf("quantile %g: got field %s, want %s", q, got, want)
+        }
+    }
+}
+
+func TestSummaryQuantileFields(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    desc := NewDesc("test_summary", "helpless", []string{"quantile_code"}, nil)
+    opts := SummaryOpts{Objectives: map[float64]float64{0.75: 0.01, 0.95: 0.005, 0.99: 0.001}}
+    vec := &SummaryVec{newMetricVec(desc, server.URL+"/metrics/doc/", EsOpts{}, func(lvs ...string) Metric {
+        return newSummary(desc, opts, lvs...)
+    })}
+    for i := 1; i <= 100; i++ {
+        vec.WithLabelValues("quantiles").Observe(float64(i))
+    }
+    vec.pushDocToEs(SUMMARY_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    for field, want := range map[string]float64{"QUANTILE_75": 75, "QUANTILE_95": 95, "QUANTILE_99": 99} {
+        if got, ok := docs[0][field].(float64); !ok || math.Abs(got-want) > 1 {
+            t.Errorf("got %s %v, want about %v", field, docs[0][field], want)
+        }
+    }
+    if _, ok := docs[0][QUANTILE_50]; ok {
+        t.Errorf("document %v has a field of an unconfigured quantile", docs[0])
+    }
+}
+
+func TestSummaryStructured(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    desc := NewDesc("test_summary", "helpless", []string{"structured_code"}, nil)
+    opts := SummaryOpts{Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01}}
+    vec := &SummaryVec{newMetricVec(desc, server.URL+"/metrics/doc/", EsOpts{
+        SummaryLayout: SummaryStructured,
+    }, func(lvs ...string) Metric {
+        return newSummary(desc, opts, lvs...)
+    })}
+    vec.WithLabelValues("observed").Observe(2)
+    vec.WithLabelValues("empty")
+    vec.pushDocToEs(SUMMARY_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 2 {
+        t.Fatalf("got %d documents, want 2", len(docs))
+    }
+    for _, doc := range docs {
+        for _, field := range []string{SUM, COUNT, QUANTILE_50, QUANTILE_90} {
+            if _, ok := doc[field]; ok {
+                t.Errorf("document %v carries the top-level field %s", doc, field)
+            }
+        }
+        want := map[string]interface{}{SUM: 2., COUNT: 1., QUANTILE_50: 2., QUANTILE_90: 2.}
+        if doc["structured_code"] == "empty" {
+            // The quantiles of an empty summary are NaN and thus omitted.
+            want = map[string]interface{}{SUM: 0., COUNT: 0.}
+        }
+        if !reflect.DeepEqual(doc[SUMMARY], want) {
+            t.Errorf("got %s %v, want %v", SUMMARY, doc[SUMMARY], want)
+        }
+    }
+}
+
+func TestNoFieldsLeakBetweenSeries(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    // Only the series with a 5xx code get the error_class label, so the
+    // documents of a push differ in their label fields.
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        Relabel: []RelabelConfig{{
+            SourceLabels: []string{"leak_code"},
+            Regex:        "leak5..",
+            TargetLabel:  "error_class",
+            Replacement:  "server",
+        }},
+    }, "leak_code")
+    for _, code := range []string{"leak500", "leak200", "leak503", "leak201"} {
+        vec.WithLabelValues(code).Inc()
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 4 {
+        t.Fatalf("got %d documents, want 4", len(docs))
+    }
+    for _, doc := range docs {
+        _, ok := doc["error_class"]
+        if want := strings.HasPrefix(doc["leak_code"].(string), "leak5"); ok != want {
+            t.Errorf("document %v has error_class %t, want %t", doc, ok, want)
+        }
+    }
+}
+
+func TestLastValuesPerVector(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vecs := []*CounterVec{
+        newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "vector_code"),
+        newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "vector_code"),
+    }
+    // Run with -race to detect unsynchronized access to the last values.
+    var wg sync.WaitGroup
+    for i, vec := range vecs {
+        wg.Add(1)
+        go func(vec *CounterVec, inc float64) {
+            defer wg.Done()
+            for j := 0; j < 10; j++ {
+                vec.WithLabelValues("same").Add(inc)
+                vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+            }
+        }(vec, float64(i+1))
+    }
+    wg.Wait()
+
+    // Both vectors have a series with the same label values. Each must
+    // compute its deltas from its own last values.
+    deltas := map[float64]int{}
+    for _, doc := range server.docs(t) {
+        deltas[doc[VALUE].(float64)]++
+    }
+    if want := map[float64]int{1: 10, 2: 10}; !reflect.DeepEqual(deltas, want) {
+        t.Errorf("got deltas %v, want %v", deltas, want)
+    }
+}
+
+func TestLastValuesBoundedUnderChurn(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "path")
+    for i := 0; i < 100; i++ {
+        path := "/churn/" + strconv.Itoa(i)
+        vec.WithLabelValues(path).Inc()
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+        if i%2 == 0 {
+            vec.DeleteLabelValues(path)
+        } else {
+            vec.Delete(Labels{"path": path})
+        }
+    }
+    if n := vec.lastValues.len(); n != 0 {
+        t.Errorf("got %d last values after deleting all series, want 0", n)
+    }
+
+    for i := 0; i < 10; i++ {
+        vec.WithLabelValues("/churn/" + strconv.Itoa(i)).Inc()
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.Reset()
+    if n := vec.lastValues.len(); n != 0 {
+        t.Errorf("got %d last values after Reset, want 0", n)
+    }
+}
+
+func TestCounterResetDelta(t *testing.T) {
+    now := time.Now()
+    lv := newLastValues()
+    for i, s := range []struct{ cur, want float64 }{{100, 100}, {5, 5}, {8, 3}} {
+        if got := lv.delta(1, s.cur, 0, now); got != s.want {
+            t.Errorf("%d. got delta %v for value %v, want %v", i, got, s.cur, s.want)
+        }
+    }
+    lv = newLastValues()
+    for i, s := range []struct {
+        cur  uint64
+        want json.Number
+    }{{100, "100"}, {5, "5"}, {8, "3"}} {
+        if got := lv.exactDelta(1, s.cur, 0, now); got != s.want {
+            t.Errorf("%d. got exact delta %v for value %v, want %v", i, got, s.cur, s.want)
+        }
+    }
+}
+
+func TestSampleTimestamp(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    explicit := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+    desc := NewDesc("test_gauge", "helpless", []string{"source"}, nil)
+    vec := newMetricVec(desc, server.URL+"/metrics/doc/", EsOpts{IdLabel: "source"}, func(lvs ...string) Metric {
+        m := MustNewConstMetric(desc, GaugeValue, 1, lvs...)
+        if lvs[0] == "explicit" {
+            return NewMetricWithTimestamp(explicit, m)
+        }
+        return m
+    })
+    vec.log = DiscardLogger
+    vec.getMetricWithLabelValues("explicit")
+    vec.getMetricWithLabelValues("implicit")
+    before := time.Now().Add(-time.Second)
+    vec.pushDocToEs(GAUGE_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 2 {
+        t.Fatalf("got %d documents, want 2", len(docs))
+    }
+    for _, doc := range docs {
+        ts, err := time.Parse(time.RFC3339, doc[TIMESTAMP].(string))
+        if err != nil {
+            t.Fatal(err)
+        }
+        switch doc["source"] {
+        case "explicit":
+            if !ts.Equal(explicit) {
+                t.Errorf("got timestamp %v, want %v", ts, explicit)
+            }
+        case "implicit":
+            if ts.Before(before) {
+                t.Errorf("got timestamp %v, want the push time", ts)
+            }
+        }
+    }
+}
+
+func TestEpochTimestamp(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    for _, legacy := range []bool{false, true} {
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+            EpochTimestamp:  true,
+            LegacyTimestamp: legacy,
+            FieldPrefix:     "m_",
+        }, "epoch_code")
+        vec.WithLabelValues("epoch").Inc()
+        before := time.Now().UnixNano() / int64(time.Millisecond)
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+        after := time.Now().UnixNano() / int64(time.Millisecond)
+
+        sources := server.sources()
+        var doc map[string]interface{}
+        dec := json.NewDecoder(bytes.NewReader(sources[len(sources)-1]))
+        dec.UseNumber()
+        if err := dec.Decode(&doc); err != nil {
+            t.Fatal(err)
+        }
+        n, ok := doc[EPOCH_TIMESTAMP].(json.Number)
+        if !ok {
+            t.Fatalf("legacy %t: got @timestamp %v, want a number", legacy, doc[EPOCH_TIMESTAMP])
+        }
+        ms, err := n.Int64()
+        if err != nil {
+            t.Fatalf("legacy %t: got @timestamp %v, want an integer: %v", legacy, n, err)
+        }
+        if ms < before || ms > after {
+            t.Errorf("legacy %t: got @timestamp %d, want between %d and %d", legacy, ms, before, after)
+        }
+        if _, ok := doc["m_"+TIMESTAMP]; ok != legacy {
+            t.Errorf("legacy %t: got Timestamp field %t", legacy, ok)
+        }
+    }
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "sync"
+    "time"
+
+    "github.com/golang/protobuf/proto"
+
+    dto "github.com/Schneizelw/elasticsearch/client_model/go"
+)
+
+// seriesActivity tracks when the value of a series last changed, as observed
+// on push. See EsOpts.MetricExpiry.
+type seriesActivity struct {
+    mtx     sync.Mutex
+    last    *dto.Metric // Without labels and timestamp.
+    changed time.Time
+}
+
+func newSeriesActivity() *seriesActivity {
+    return &seriesActivity{changed: time.Now()}
+}
+
+// expired records the current value dtoMetric of the series observed at now
+// and reports whether it has not changed for longer than expiry.
+func (a *seriesActivity) expired(dtoMetric dto.Metric, now time.Time, expiry time.Duration) bool {
+    dtoMetric.Label = nil
+    dtoMetric.TimestampMs = nil
+
+    a.mtx.Lock()
+    defer a.mtx.Unlock()
+
+    if a.last == nil || !proto.Equal(a.last, &dtoMetric) {
+        a.last = &dtoMetric
+        a.changed = now
+    }
+    return now.Sub(a.changed) > expiry
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync/atomic"
+)
+
+// validateFailoverURLs returns an error if any of esOpts.FailoverURLs is not
+// the root URL of a node.
+func validateFailoverURLs(esOpts EsOpts) error {
+    for _, u := range esOpts.FailoverURLs {
+        parsed, err := url.Parse(u)
+        if err != nil {
+            return fmt.Errorf("invalid failover URL %q: %v", u, err)
+        }
+        if parsed.Scheme != "http" && parsed.Scheme != "https" {
+            return fmt.Errorf("failover URL %q must use http or https", u)
+        }
+        if parsed.Host == "" || strings.Trim(parsed.Path, "/") != "" {
+            return fmt.Errorf("failover URL %q must be the root URL of a node", u)
+        }
+    }
+    return nil
+}
+
+// failoverRoots returns the root URLs of the nodes to send the requests to, in
+// order, i.e. the root of the index URL u followed by esOpts.FailoverURLs. It
+// returns nil if there are no failover URLs.
+func failoverRoots(u string, esOpts EsOpts) []string {
+    if len(esOpts.FailoverURLs) == 0 || u == "" {
+        return nil
+    }
+    root, err := rootURL(u)
+    if err != nil {
+        return nil
+    }
+    roots := []string{root}
+    for _, f := range esOpts.FailoverURLs {
+        roots = append(roots, strings.TrimSuffix(f, "/")+"/")
+    }
+    return roots
+}
+
+// failoverError is returned if a request failed on all nodes.
+type failoverError struct {
+    errs []error // Per node, in the order they were tried.
+}
+
+func (e *failoverError) Error() string {
+    msgs := make([]string, len(e.errs))
+    for i, err := range e.errs {
+        msgs[i] = err.Error()
+    }
+    return fmt.Sprintf("all %d nodes failed: %s", len(e.errs), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the error of the node tried last.
+func (e *failoverError) Unwrap() error {
+    return e.errs[len(e.errs)-1]
+}
+
+// failover reports whether a request that failed with err is to be sent to
+// the next node.
+func failover(ctx context.Context, err error) bool {
+    if ctx.Err() != nil {
+        return false
+    }
+    if e, ok := err.(*statusError); ok {
+        switch e.code {
+        case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+            return true
+        }
+        return false
+    }
+    // The node could not be reached.
+    return true
+}
+
+// requestFailover sends a request like send to the nodes of EsOpts.FailoverURLs
+// in turn, starting with the node that last responded, until one does. The
+// URL u must address the node of the index URL.
+func (m *metricMap) requestFailover(
+    ctx context.Context, method, u, contentType string, body []byte, compress bool,
+) ([]byte, error) {
+    if !strings.HasPrefix(u, m.failoverRoots[0]) {
+        return m.send(ctx, method, u, contentType, body, compress)
+    }
+    path := strings.TrimPrefix(u, m.failoverRoots[0])
+    start := int(atomic.LoadInt32(&m.failoverNode))
+    var errs []error
+    for i := range m.failoverRoots {
+        node := (start + i) % len(m.failoverRoots)
+        res, err := m.send(ctx, method, m.failoverRoots[node]+path, contentType, body, compress)
+        if err == nil || !failover(ctx, err) {
+            if err == nil {
+                atomic.StoreInt32(&m.failoverNode, int32(node))
+            }
+            return res, err
+        }
+        errs = append(errs, err)
+    }
+    return nil, &failoverError{errs}
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "errors"
+    "net/http"
+    "sync"
+    "testing"
+)
+
+func TestFailover(t *testing.T) {
+    var (
+        mtx  sync.Mutex
+        down int // Requests received by the failing node.
+    )
+    first := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        down++
+        mtx.Unlock()
+        w.WriteHeader(http.StatusServiceUnavailable)
+    })
+    defer first.Close()
+    second := newTestServer()
+    defer second.Close()
+
+    vec := newTestCounterVec(first.URL+"/metrics/doc/", EsOpts{
+        FailoverURLs: []string{second.URL + "/"},
+    }, "failover_code")
+    vec.WithLabelValues("a").Inc()
+    vec.WithLabelValues("b").Inc()
+    if err := vec.pushDocToEs(COUNTER_TYPE, DiscardLogger); err != nil {
+        t.Fatal(err)
+    }
+    if docs := second.docs(t); len(docs) != 2 {
+        t.Fatalf("got %d documents on the second node, want 2", len(docs))
+    }
+
+    // The second node is preferred now.
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    mtx.Lock()
+    if down != 1 {
+        t.Errorf("got %d requests to the failing node, want 1", down)
+    }
+    mtx.Unlock()
+    second.mtx.Lock()
+    if len(second.requests) != 2 {
+        t.Errorf("got %d requests to the second node, want 2", len(second.requests))
+    }
+    second.mtx.Unlock()
+}
+
+func TestFailoverAllNodesDown(t *testing.T) {
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusBadGateway)
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        FailoverURLs: []string{server.URL, "http://127.0.0.1:1/"},
+    })
+    vec.WithLabelValues().Inc()
+    err := vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    var fe *failoverError
+    if !errors.As(err, &fe) || len(fe.errs) != 3 {
+        t.Fatalf("got error %v, want one listing the failures of all 3 nodes", err)
+    }
+    var se *statusError
+    if !errors.As(fe.errs[0], &se) || se.code != http.StatusBadGateway {
+        t.Errorf("got error %v of the first node, want status 502", fe.errs[0])
+    }
+
+    for _, u := range []string{"es-2:9200", "ftp://es-2/", "http://es-2:9200/metrics/"} {
+        if err := validateFailoverURLs(EsOpts{FailoverURLs: []string{u}}); err == nil {
+            t.Errorf("%s: expected an error", u)
+        }
+    }
+}
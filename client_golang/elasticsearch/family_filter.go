@@ -0,0 +1,47 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "fmt"
+    "regexp"
+)
+
+// matchesAny reports whether fqName fully matches any of the regular
+// expressions patterns.
+func matchesAny(fqName string, patterns []string) (bool, error) {
+    for _, p := range patterns {
+        regex, err := regexp.Compile("^(?:" + p + ")$")
+        if err != nil {
+            return false, fmt.Errorf("invalid metric pattern %q: %v", p, err)
+        }
+        if regex.MatchString(fqName) {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// shipFamily reports whether the metric family fqName is pushed according to
+// EsOpts.MetricAllowlist and EsOpts.MetricDenylist.
+func shipFamily(fqName string, esOpts EsOpts) (bool, error) {
+    denied, err := matchesAny(fqName, esOpts.MetricDenylist)
+    if err != nil || denied {
+        return false, err
+    }
+    if len(esOpts.MetricAllowlist) == 0 {
+        return true, nil
+    }
+    return matchesAny(fqName, esOpts.MetricAllowlist)
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "testing"
+)
+
+func TestShipFamily(t *testing.T) {
+    scenarios := []struct {
+        allow, deny []string
+        want        bool
+    }{
+        {want: true},
+        {allow: []string{"http_.*"}, want: true},
+        {allow: []string{"http"}, want: false},
+        {allow: []string{"rpc_.*"}, want: false},
+        {deny: []string{".*_total"}, want: false},
+        {allow: []string{"http_.*"}, deny: []string{"http_requests_.*"}, want: false},
+    }
+    for i, s := range scenarios {
+        got, err := shipFamily("http_requests_total", EsOpts{MetricAllowlist: s.allow, MetricDenylist: s.deny})
+        if err != nil {
+            t.Errorf("%d. unexpected error: %v", i, err)
+        } else if got != s.want {
+            t.Errorf("%d. got %t, want %t", i, got, s.want)
+        }
+    }
+    if _, err := shipFamily("http_requests_total", EsOpts{MetricDenylist: []string{"("}}); err == nil {
+        t.Error("expected error for invalid pattern")
+    }
+}
+
+func TestMetricDenylist(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{MetricDenylist: []string{"test_.*"}}, "deny_code")
+    vec.WithLabelValues("deny").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if docs := server.docs(t); len(docs) != 0 {
+        t.Errorf("got %d documents of a denied family, want none", len(docs))
+    }
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+// FieldNames remaps the names of the fields set by this package to match the
+// conventions of an existing index template. An empty name keeps the default,
+// i.e. the constant named in the comment. See EsOpts.FieldNames.
+type FieldNames struct {
+    Value     string // VALUE
+    Sum       string // SUM
+    Count     string // COUNT
+    Help      string // HELP
+    Type      string // TYPE
+    Timestamp string // TIMESTAMP
+    FqName    string // FQNAME
+}
+
+// renames returns the non-default field names by default name.
+func (n FieldNames) renames() map[string]string {
+    renames := map[string]string{}
+    for field, name := range map[string]string{
+        VALUE:     n.Value,
+        SUM:       n.Sum,
+        COUNT:     n.Count,
+        HELP:      n.Help,
+        TYPE:      n.Type,
+        TIMESTAMP: n.Timestamp,
+        FQNAME:    n.FqName,
+    } {
+        if name != "" && name != field {
+            renames[field] = name
+        }
+    }
+    return renames
+}
+
+// renameFields returns a copy of doc with the top-level fields renamed as
+// given by renames.
+func renameFields(doc map[string]interface{}, renames map[string]string) map[string]interface{} {
+    renamed := make(map[string]interface{}, len(doc))
+    for k, v := range doc {
+        if name, ok := renames[k]; ok {
+            k = name
+        }
+        renamed[k] = v
+    }
+    return renamed
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "testing"
+)
+
+func TestFieldNames(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        FieldNames: FieldNames{Value: "value", FqName: "__name__", Timestamp: "@ts"},
+    }, "value", "Value", "code")
+    vec.WithLabelValues("label value", "former field", "200").Add(3)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    doc := docs[0]
+    for _, field := range []string{FQNAME, TIMESTAMP} {
+        if _, ok := doc[field]; ok {
+            t.Errorf("got field %s, want it renamed", field)
+        }
+    }
+    // Value is no field name of this package anymore, so the label of that
+    // name is not renamed.
+    if doc[VALUE] != "former field" {
+        t.Errorf("got field %s %v, want the label value", VALUE, doc[VALUE])
+    }
+    if doc["value"] != 3.0 || doc["__name__"] != "test_counter" || doc["@ts"] == nil {
+        t.Errorf("unexpected document %v", doc)
+    }
+    if doc[collidingLabelPrefix+"value"] != "label value" || doc[TYPE] != METRIC_COUNTER {
+        t.Errorf("unexpected document %v", doc)
+    }
+
+    expected := vec.expectedMapping(COUNTER_TYPE)
+    if expected["value"] != "double" || expected["@ts"] != "date" || expected[VALUE] != "keyword" {
+        t.Errorf("unexpected mapping %v", expected)
+    }
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// flushBuffer collects the documents of several pushes until EsOpts.FlushDocs
+// documents are pending or EsOpts.FlushInterval has passed since the last
+// flush, whichever comes first.
+type flushBuffer struct {
+    maxDocs  int           // 0 means no limit.
+    interval time.Duration // 0 means no time limit.
+
+    mtx       sync.Mutex
+    pending   *docBatch   // nil if no documents are pending.
+    timer     *time.Timer // Flushes pending, nil if no documents are pending.
+    lastFlush time.Time
+}
+
+// take returns the pending documents, if any, and empties the buffer. It must
+// be called while holding the mutex.
+func (b *flushBuffer) take() *docBatch {
+    batch := b.pending
+    if b.timer != nil {
+        b.timer.Stop()
+    }
+    b.pending, b.timer = nil, nil
+    b.lastFlush = time.Now()
+    return batch
+}
+
+// sameURLs reports whether a and b hold the same index URLs in the same order.
+func sameURLs(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// bufferBatch adds the documents of batch to the flush buffer and sends the
+// pending documents if EsOpts.FlushDocs is reached. As the documents of a
+// batch are sent together, the pending documents are also sent first if the
+// index URLs of batch differ from theirs, e.g. because of
+// EsOpts.IndexDatePattern. Failures are logged and returned like those of
+// sendBatch.
+func (m *metricMap) bufferBatch(batch *docBatch) error {
+    b := m.flushBuffer
+    var full []*docBatch
+    b.mtx.Lock()
+    if b.pending != nil && !sameURLs(b.pending.urls, batch.urls) {
+        full = append(full, b.take())
+    }
+    if b.pending == nil {
+        pending := &docBatch{urls: batch.urls, log: batch.log}
+        b.pending = pending
+        if b.interval > 0 {
+            delay := b.interval - time.Since(b.lastFlush)
+            b.timer = time.AfterFunc(delay, func() { m.flushTimeout(pending) })
+        }
+    }
+    for _, doc := range batch.docs {
+        b.pending.add(doc)
+    }
+    if b.maxDocs > 0 && len(b.pending.docs) >= b.maxDocs {
+        full = append(full, b.take())
+    }
+    b.mtx.Unlock()
+
+    var err error
+    for _, batch := range full {
+        if sendErr := m.sendFlushed(batch); sendErr != nil {
+            err = sendErr
+        }
+    }
+    return err
+}
+
+// flushTimeout sends the documents of pending once EsOpts.FlushInterval has
+// passed, unless they have been sent in the meantime.
+func (m *metricMap) flushTimeout(pending *docBatch) {
+    b := m.flushBuffer
+    b.mtx.Lock()
+    if b.pending != pending {
+        b.mtx.Unlock()
+        return
+    }
+    batch := b.take()
+    b.mtx.Unlock()
+    m.sendFlushed(batch)
+}
+
+// sendFlushed sends batch, or queues it if EsOpts.Async is set and the vector
+// has not been stopped.
+func (m *metricMap) sendFlushed(batch *docBatch) error {
+    if m.buffer != nil && m.buffer.enqueue(batch) {
+        return nil
+    }
+    return m.sendBatch(context.Background(), batch)
+}
+
+// Flush sends the documents buffered as configured by EsOpts.FlushDocs and
+// EsOpts.FlushInterval right away, e.g. on shutdown. If any document could
+// not be written, a *PushError is returned. The documents are sent
+// synchronously, also if EsOpts.Async is set. Flush is a no-op if no
+// documents are buffered.
+func (m *metricMap) Flush() error {
+    b := m.flushBuffer
+    if b == nil {
+        return nil
+    }
+    b.mtx.Lock()
+    batch := b.take()
+    b.mtx.Unlock()
+    if batch == nil {
+        return nil
+    }
+    return m.sendBatch(context.Background(), batch)
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "strconv"
+    "testing"
+    "time"
+)
+
+func TestFlushInterval(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        FlushDocs:     10,
+        FlushInterval: 100 * time.Millisecond,
+    }, "flush_code")
+    for i := 0; i < 3; i++ {
+        vec.WithLabelValues(strconv.Itoa(i)).Inc()
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if docs := server.docs(t); len(docs) != 0 {
+        t.Fatalf("got %d documents before the flush interval, want 0", len(docs))
+    }
+
+    deadline := time.Now().Add(time.Second)
+    for len(server.docs(t)) == 0 {
+        if time.Now().After(deadline) {
+            t.Fatal("buffered documents not flushed on the timer")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    server.mtx.Lock()
+    defer server.mtx.Unlock()
+    if len(server.requests) != 1 {
+        t.Errorf("got %d requests, want 1 for both pushes", len(server.requests))
+    }
+    if lines := bulkLines(server.requests[0].body); len(lines) != 2*6 {
+        t.Errorf("got %d lines, want %d", len(lines), 2*6)
+    }
+}
+
+func TestFlushDocs(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{FlushDocs: 4}, "flush_code")
+    for i := 0; i < 3; i++ {
+        vec.WithLabelValues(strconv.Itoa(i)).Inc()
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if docs := server.docs(t); len(docs) != 0 {
+        t.Fatalf("got %d documents below FlushDocs, want 0", len(docs))
+    }
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if docs := server.docs(t); len(docs) != 6 {
+        t.Fatalf("got %d documents after reaching FlushDocs, want 6", len(docs))
+    }
+
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if err := vec.Flush(); err != nil {
+        t.Fatal(err)
+    }
+    if docs := server.docs(t); len(docs) != 9 {
+        t.Errorf("got %d documents after Flush, want 9", len(docs))
+    }
+}
@@ -14,7 +14,9 @@
 package elasticsearch
 
 import (
+    "context"
     "math"
+    "sync"
     "sync/atomic"
     "time"
 
@@ -86,6 +88,57 @@ type gauge struct {
 
     desc       *Desc
     labelPairs []*dto.LabelPair
+
+    // agg aggregates the values between pushes if EsOpts.GaugeAggregates
+    // is set. Otherwise, it is nil.
+    agg *gaugeAggregates
+}
+
+// gaugeAggregates tracks minimum, maximum, and average of the values a gauge
+// had since they were last taken.
+type gaugeAggregates struct {
+    mtx           sync.Mutex
+    min, max, sum float64
+    count         uint64
+}
+
+func (a *gaugeAggregates) observe(val float64) {
+    a.mtx.Lock()
+    defer a.mtx.Unlock()
+
+    if a.count == 0 || val < a.min {
+        a.min = val
+    }
+    if a.count == 0 || val > a.max {
+        a.max = val
+    }
+    a.sum += val
+    a.count++
+}
+
+// take returns minimum, maximum, and average of the observed values and
+// resets the aggregates. If no values were observed, last is used for all
+// three.
+func (a *gaugeAggregates) take(last float64) (min, max, avg float64) {
+    a.mtx.Lock()
+    defer a.mtx.Unlock()
+
+    if a.count == 0 {
+        return last, last, last
+    }
+    min, max, avg = a.min, a.max, a.sum/float64(a.count)
+    a.min, a.max, a.sum, a.count = 0, 0, 0, 0
+    return min, max, avg
+}
+
+// setAggregates implements aggregator.
+func (g *gauge) setAggregates(docMap map[string]interface{}) {
+    if g.agg == nil {
+        return
+    }
+    last := math.Float64frombits(atomic.LoadUint64(&g.valBits))
+    docMap[MIN], docMap[MAX], docMap[AVG] = g.agg.take(last)
+    docMap[LAST] = last
 }
 
 func (g *gauge) Desc() *Desc {
@@ -94,6 +147,9 @@ func (g *gauge) Desc() *Desc {
 
 func (g *gauge) Set(val float64) {
     atomic.StoreUint64(&g.valBits, math.Float64bits(val))
+    if g.agg != nil {
+        g.agg.observe(val)
+    }
 }
 
 func (g *gauge) SetToCurrentTime() {
@@ -111,8 +167,11 @@ func (g *gauge) Dec() {
 func (g *gauge) Add(val float64) {
     for {
         oldBits := atomic.LoadUint64(&g.valBits)
-        newBits := math.Float64bits(math.Float64frombits(oldBits) + val)
-        if atomic.CompareAndSwapUint64(&g.valBits, oldBits, newBits) {
+        newVal := math.Float64frombits(oldBits) + val
+        if atomic.CompareAndSwapUint64(&g.valBits, oldBits, math.Float64bits(newVal)) {
+            if g.agg != nil {
+                g.agg.observe(newVal)
+            }
             return
         }
     }
@@ -145,32 +204,66 @@ func NewGaugeVec(opts GaugeOpts, esOpts GaugeEsOpts, labelNames []string) *Gauge
         labelNames,
         opts.ConstLabels,
     )
-    url := BuildEsUrl(esOpts.Host, esOpts.Port, esOpts.EsIndex, esOpts.EsType)
+    url := indexURL(EsOpts(esOpts))
     gv := GaugeVec{
-        metricVec: newMetricVec(desc, url, func(lvs ...string) Metric {
+        metricVec: newMetricVec(desc, url, EsOpts(esOpts), func(lvs ...string) Metric {
             if len(lvs) != len(desc.variableLabels) {
                 panic(makeInconsistentCardinalityError(desc.fqName, desc.variableLabels, lvs))
             }
             result := &gauge{desc: desc, labelPairs: makeLabelPairs(desc, lvs)}
+            if esOpts.GaugeAggregates {
+                result.agg = &gaugeAggregates{}
+            }
             result.init(result) // Init self-collection.
             return result
         }),
     }
-    go gv.monitor(esOpts.Interval, desc.fqName)
+    if esOpts.Interval > 0 {
+        go gv.monitor(esOpts.Interval, desc.fqName)
+    }
     return &gv
 }
 
 func (v *GaugeVec) monitor(second int, fqName string) {
     gaugeType := 2
     ticker := time.NewTicker(time.Duration(second)*time.Second)
-    gaugeLog := SetLog(fqName + WARN)
+    gaugeLog := newLogger(fqName, v.metricVec.metricMap.esOpts)
+    defer ticker.Stop()
     for {
-        <-ticker.C
+        select {
+        case <-v.metricVec.metricMap.stop:
+            return
+        case <-ticker.C:
+        }
         //2 is gauge metric
         v.metricVec.metricMap.pushDocToEs(gaugeType, gaugeLog)
     }
 }
 
+// ResetAndPush pushes the current values of all gauges in this vector and
+// then deletes them, like Reset. Use it to keep the final values, e.g. at the
+// end of a processing phase. Cancelling ctx aborts the push.
+func (v *GaugeVec) ResetAndPush(ctx context.Context) {
+    v.metricVec.metricMap.resetAndPush(ctx, GAUGE_TYPE)
+}
+
+// PushContext pushes the current values of all gauges in this vector right
+// away, in addition to the periodic pushes. If any document could not be
+// written, a *PushError is returned. The documents are sent synchronously,
+// also if EsOpts.Async is set. Cancelling ctx aborts the push, e.g. on
+// shutdown, and the returned error then wraps ctx.Err().
+func (v *GaugeVec) PushContext(ctx context.Context) error {
+    return v.metricVec.metricMap.pushContext(ctx, GAUGE_TYPE)
+}
+
+func (v *GaugeVec) metricType() int {
+    return GAUGE_TYPE
+}
+
+func (v *GaugeVec) push() {
+    v.metricVec.metricMap.pushDocToEs(GAUGE_TYPE, v.metricVec.metricMap.logger())
+}
+
 // GetMetricWithLabelValues returns the Gauge for the given slice of label
 // values (same order as the VariableLabels in Desc). If that combination of
 // label values is accessed for the first time, a new Gauge is created.
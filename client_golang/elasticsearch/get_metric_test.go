@@ -0,0 +1,89 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "testing"
+)
+
+func TestGetMetricWith(t *testing.T) {
+    gaugeDesc := NewDesc("test_gauge", "helpless", []string{"code", "method"}, nil)
+    gaugeVec := &GaugeVec{newMetricVec(gaugeDesc, "", EsOpts{}, func(lvs ...string) Metric {
+        result := &gauge{desc: gaugeDesc, labelPairs: makeLabelPairs(gaugeDesc, lvs)}
+        result.init(result)
+        return result
+    })}
+    summaryDesc := NewDesc("test_summary", "helpless", []string{"code", "method"}, nil)
+    summaryVec := &SummaryVec{newMetricVec(summaryDesc, "", EsOpts{}, func(lvs ...string) Metric {
+        return newSummary(summaryDesc, SummaryOpts{}, lvs...)
+    })}
+    counterVec := newTestCounterVec("", EsOpts{}, "code", "method")
+    histogramVec := newTestHistogramVec("", EsOpts{}, nil, "code", "method")
+
+    scenarios := []struct {
+        name     string
+        byValues func(...string) (interface{}, error)
+        byLabels func(Labels) (interface{}, error)
+    }{
+        {
+            name:     "counter",
+            byValues: func(lvs ...string) (interface{}, error) { return counterVec.GetMetricWithLabelValues(lvs...) },
+            byLabels: func(l Labels) (interface{}, error) { return counterVec.GetMetricWith(l) },
+        },
+        {
+            name:     "gauge",
+            byValues: func(lvs ...string) (interface{}, error) { return gaugeVec.GetMetricWithLabelValues(lvs...) },
+            byLabels: func(l Labels) (interface{}, error) { return gaugeVec.GetMetricWith(l) },
+        },
+        {
+            name:     "histogram",
+            byValues: func(lvs ...string) (interface{}, error) { return histogramVec.GetMetricWithLabelValues(lvs...) },
+            byLabels: func(l Labels) (interface{}, error) { return histogramVec.GetMetricWith(l) },
+        },
+        {
+            name:     "summary",
+            byValues: func(lvs ...string) (interface{}, error) { return summaryVec.GetMetricWithLabelValues(lvs...) },
+            byLabels: func(l Labels) (interface{}, error) { return summaryVec.GetMetricWith(l) },
+        },
+    }
+    for _, s := range scenarios {
+        byValues, err := s.byValues("200", "GET")
+        if err != nil {
+            t.Fatalf("%s: unexpected error: %v", s.name, err)
+        }
+        byLabels, err := s.byLabels(Labels{"method": "GET", "code": "200"})
+        if err != nil {
+            t.Fatalf("%s: unexpected error: %v", s.name, err)
+        }
+        if byValues != byLabels {
+            t.Errorf("%s: got different metrics for the same labels by values and by map", s.name)
+        }
+        if other, _ := s.byValues("404", "GET"); other == byValues {
+            t.Errorf("%s: got the same metric for different label values", s.name)
+        }
+
+        if m, err := s.byValues("200"); err == nil || m != nil {
+            t.Errorf("%s: got %v, %v for too few label values, want an error", s.name, m, err)
+        }
+        if m, err := s.byValues("200", "GET", "extra"); err == nil || m != nil {
+            t.Errorf("%s: got %v, %v for too many label values, want an error", s.name, m, err)
+        }
+        if m, err := s.byLabels(Labels{"code": "200"}); err == nil || m != nil {
+            t.Errorf("%s: got %v, %v for too few labels, want an error", s.name, m, err)
+        }
+        if m, err := s.byLabels(Labels{"code": "200", "verb": "GET"}); err == nil || m != nil {
+            t.Errorf("%s: got %v, %v for an unknown label, want an error", s.name, m, err)
+        }
+    }
+}
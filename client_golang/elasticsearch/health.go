@@ -0,0 +1,70 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "sync"
+    "time"
+)
+
+// PushHealth reports the outcome of the recent pushes of a metric vector. A
+// push fails if any of its documents could not be written.
+type PushHealth struct {
+    // LastSuccess is the time of the last successful push.
+    LastSuccess time.Time
+    // ConsecutiveFailures is the number of pushes that failed since then.
+    ConsecutiveFailures int
+    // FailingSince is the time of the first of these failures.
+    FailingSince time.Time
+    // LastError is the error of the last failed push.
+    LastError error
+}
+
+// Ready reports whether the pushes have not been failing for longer than
+// threshold, e.g. to implement a readiness probe. It is true if no push has
+// been attempted yet.
+func (h PushHealth) Ready(threshold time.Duration) bool {
+    return h.ConsecutiveFailures == 0 || time.Since(h.FailingSince) <= threshold
+}
+
+// pushHealth tracks the PushHealth of a metric vector.
+type pushHealth struct {
+    mtx    sync.Mutex
+    health PushHealth
+}
+
+// record records the outcome of a push finished at now, which failed if err is
+// not nil.
+func (p *pushHealth) record(err error, now time.Time) {
+    p.mtx.Lock()
+    defer p.mtx.Unlock()
+
+    if err == nil {
+        p.health = PushHealth{LastSuccess: now}
+        return
+    }
+    if p.health.ConsecutiveFailures == 0 {
+        p.health.FailingSince = now
+    }
+    p.health.ConsecutiveFailures++
+    p.health.LastError = err
+}
+
+// PushHealth returns the outcome of the recent pushes of this vector.
+func (m *metricMap) PushHealth() PushHealth {
+    m.health.mtx.Lock()
+    defer m.health.mtx.Unlock()
+
+    return m.health.health
+}
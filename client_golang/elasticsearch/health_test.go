@@ -0,0 +1,67 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "net/http"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestPushHealth(t *testing.T) {
+    var failing int32
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        if atomic.LoadInt32(&failing) == 1 {
+            w.WriteHeader(http.StatusInternalServerError)
+            return
+        }
+        w.Write([]byte(`{"errors":false,"items":[]}`))
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "health_code")
+    vec.log = DiscardLogger
+    vec.WithLabelValues("health").Inc()
+    if h := vec.PushHealth(); !h.Ready(0) || !h.LastSuccess.IsZero() {
+        t.Errorf("got %+v before the first push, want ready", h)
+    }
+
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    h := vec.PushHealth()
+    if h.LastSuccess.IsZero() || h.ConsecutiveFailures != 0 || !h.Ready(0) {
+        t.Errorf("got %+v after a successful push, want ready", h)
+    }
+
+    atomic.StoreInt32(&failing, 1)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    h = vec.PushHealth()
+    if h.ConsecutiveFailures != 2 || h.LastError == nil || h.LastSuccess.IsZero() {
+        t.Errorf("got %+v after two failed pushes", h)
+    }
+    if !h.Ready(time.Hour) {
+        t.Error("not ready although failing for less than the threshold")
+    }
+    time.Sleep(10 * time.Millisecond)
+    if h.Ready(5 * time.Millisecond) {
+        t.Error("ready although failing for longer than the threshold")
+    }
+
+    atomic.StoreInt32(&failing, 0)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if h := vec.PushHealth(); h.ConsecutiveFailures != 0 || h.LastError != nil {
+        t.Errorf("got %+v after recovering, want no failures", h)
+    }
+}
@@ -14,12 +14,14 @@
 package elasticsearch
 
 import (
+    "context"
     "fmt"
     "math"
     "runtime"
     "sort"
     "sync"
     "sync/atomic"
+    "time"
 
     "github.com/golang/protobuf/proto"
 
@@ -372,12 +374,54 @@ func NewHistogramVec(opts HistogramOpts, esOpts HistogramEsOpts, labelNames []st
         labelNames,
         opts.ConstLabels,
     )
-    url := BuildEsUrl(esOpts.Host, esOpts.Port, esOpts.EsIndex, esOpts.EsType)
-    return &HistogramVec{
-        metricVec: newMetricVec(desc, url, func(lvs ...string) Metric {
+    url := indexURL(EsOpts(esOpts))
+    hv := HistogramVec{
+        metricVec: newMetricVec(desc, url, EsOpts(esOpts), func(lvs ...string) Metric {
             return newHistogram(desc, opts, lvs...)
         }),
     }
+    if esOpts.Interval > 0 {
+        go hv.monitor(esOpts.Interval, desc.fqName)
+    }
+    return &hv
+}
+
+func (v *HistogramVec) monitor(second int, fqName string) {
+    ticker := time.NewTicker(time.Duration(second)*time.Second)
+    histogramLog := newLogger(fqName, v.metricVec.metricMap.esOpts)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-v.metricVec.metricMap.stop:
+            return
+        case <-ticker.C:
+        }
+        v.metricVec.metricMap.pushDocToEs(HISTOGRAM_TYPE, histogramLog)
+    }
+}
+
+// ResetAndPush pushes the current values of all histograms in this vector and
+// then deletes them, like Reset. Use it to keep the final values, e.g. at the
+// end of a processing phase. Cancelling ctx aborts the push.
+func (v *HistogramVec) ResetAndPush(ctx context.Context) {
+    v.metricVec.metricMap.resetAndPush(ctx, HISTOGRAM_TYPE)
+}
+
+// PushContext pushes the current values of all histograms in this vector right
+// away, in addition to the periodic pushes. If any document could not be
+// written, a *PushError is returned. The documents are sent synchronously,
+// also if EsOpts.Async is set. Cancelling ctx aborts the push, e.g. on
+// shutdown, and the returned error then wraps ctx.Err().
+func (v *HistogramVec) PushContext(ctx context.Context) error {
+    return v.metricVec.metricMap.pushContext(ctx, HISTOGRAM_TYPE)
+}
+
+func (v *HistogramVec) metricType() int {
+    return HISTOGRAM_TYPE
+}
+
+func (v *HistogramVec) push() {
+    v.metricVec.metricMap.pushDocToEs(HISTOGRAM_TYPE, v.metricVec.metricMap.logger())
 }
 
 // GetMetricWithLabelValues returns the Histogram for the given slice of label
@@ -0,0 +1,104 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "reflect"
+    "testing"
+
+    dto "github.com/Schneizelw/elasticsearch/client_model/go"
+)
+
+func TestHistogramVecPush(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := NewHistogramVec(HistogramOpts{
+        Name:    "test_request_duration_seconds",
+        Help:    "helpless",
+        Buckets: []float64{0.1, 1, 10},
+    }, HistogramEsOpts{
+        URL:      server.URL + "/metrics/doc/",
+        Interval: 3600,
+    }, []string{"method"})
+    for _, v := range []float64{0.05, 0.5, 0.5, 5, 50} {
+        vec.WithLabelValues("GET").Observe(v)
+    }
+    vec.WithLabelValues("POST").Observe(2)
+
+    reg := NewPedanticRegistry()
+    reg.MustRegister(vec)
+    mfs, err := reg.Gather()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(mfs) != 1 || mfs[0].GetType() != dto.MetricType_HISTOGRAM || len(mfs[0].GetMetric()) != 2 {
+        t.Fatalf("got metric families %v, want one histogram with 2 series", mfs)
+    }
+
+    if err := vec.PushContext(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    docs := server.docs(t)
+    if len(docs) != 2 {
+        t.Fatalf("got %d documents, want 2", len(docs))
+    }
+    type bucket struct{ le, count float64 }
+    want := map[string]struct {
+        sum, count float64
+        buckets    []bucket
+    }{
+        "GET":  {56.05, 5, []bucket{{0.1, 1}, {1, 3}, {10, 4}}},
+        "POST": {2, 1, []bucket{{0.1, 0}, {1, 0}, {10, 1}}},
+    }
+    for _, doc := range docs {
+        method := doc["method"].(string)
+        w := want[method]
+        if doc[TYPE] != METRIC_HISTOGRAM || doc[FQNAME] != "test_request_duration_seconds" {
+            t.Errorf("%s: unexpected document %v", method, doc)
+        }
+        if doc[SUM] != w.sum || doc[COUNT] != w.count {
+            t.Errorf("%s: got sum %v and count %v, want %v and %v", method, doc[SUM], doc[COUNT], w.sum, w.count)
+        }
+        var buckets []bucket
+        for _, b := range doc[BUCKETS].([]interface{}) {
+            b := b.(map[string]interface{})
+            buckets = append(buckets, bucket{b[LE].(float64), b[COUNT].(float64)})
+        }
+        if !reflect.DeepEqual(buckets, w.buckets) {
+            t.Errorf("%s: got buckets %v, want %v", method, buckets, w.buckets)
+        }
+    }
+}
+
+func TestHistogramVecWithoutInterval(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := NewHistogramVec(HistogramOpts{
+        Name:    "test_request_duration_seconds",
+        Help:    "helpless",
+        Buckets: []float64{0.1, 1, 10},
+    }, HistogramEsOpts{
+        URL: server.URL + "/metrics/doc/",
+    }, []string{"method"})
+    vec.WithLabelValues("GET").Observe(0.5)
+    if err := vec.PushContext(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    if got := len(server.docs(t)); got != 1 {
+        t.Errorf("got %d documents, want 1", got)
+    }
+}
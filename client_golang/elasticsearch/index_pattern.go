@@ -0,0 +1,62 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "fmt"
+    "strings"
+    "time"
+)
+
+// validateIndexDatePattern checks that EsOpts.IndexDatePattern only uses known
+// directives.
+func validateIndexDatePattern(esOpts EsOpts) error {
+    if esOpts.IndexDatePattern == "" {
+        return nil
+    }
+    _, err := expandIndexPattern(esOpts.IndexDatePattern, time.Time{})
+    return err
+}
+
+// expandIndexPattern replaces the directives of the index name pattern with
+// the fields of t in UTC: %Y by the year, %m by the month, %d by the day, %H
+// by the hour, all zero-padded, and %% by a literal %.
+func expandIndexPattern(pattern string, t time.Time) (string, error) {
+    t = t.UTC()
+    var b strings.Builder
+    for i := 0; i < len(pattern); i++ {
+        if pattern[i] != '%' {
+            b.WriteByte(pattern[i])
+            continue
+        }
+        if i++; i == len(pattern) {
+            return "", fmt.Errorf("index pattern %q ends with %%", pattern)
+        }
+        switch pattern[i] {
+        case 'Y':
+            fmt.Fprintf(&b, "%04d", t.Year())
+        case 'm':
+            fmt.Fprintf(&b, "%02d", t.Month())
+        case 'd':
+            fmt.Fprintf(&b, "%02d", t.Day())
+        case 'H':
+            fmt.Fprintf(&b, "%02d", t.Hour())
+        case '%':
+            b.WriteByte('%')
+        default:
+            return "", fmt.Errorf("index pattern %q has unknown directive %%%c", pattern, pattern[i])
+        }
+    }
+    return b.String(), nil
+}
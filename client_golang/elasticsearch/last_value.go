@@ -0,0 +1,183 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "encoding/json"
+    "math"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// lastValue is the value of a counter series at its last push.
+type lastValue struct {
+    value float64
+    exact uint64 // The value as an exact integer, see exactDelta.
+    seen  time.Time
+}
+
+// lastValues holds the lastValue of every pushed counter series of a metric
+// vector, keyed by the hash of its label values. It is used to index the delta
+// since the last push.
+type lastValues struct {
+    mtx    sync.Mutex
+    values map[uint64]lastValue
+
+    // Values read from EsOpts.LastValueFile, keyed by series key, of the
+    // series not pushed since.
+    restored map[string]lastValue
+}
+
+func newLastValues() *lastValues {
+    return &lastValues{values: map[uint64]lastValue{}}
+}
+
+// delta returns the difference of cur to the last value of the series hash
+// and records cur as the last value, both seen at now. A difference whose
+// magnitude does not exceed tolerance is returned as zero and not recorded, so
+// that small increments add up over several pushes rather than getting lost.
+// If cur is less than the last value, the counter has been reset in between,
+// and cur itself is returned instead of a negative difference.
+func (l *lastValues) delta(hash uint64, cur, tolerance float64, now time.Time) float64 {
+    l.mtx.Lock()
+    defer l.mtx.Unlock()
+
+    last := l.values[hash]
+    delta := cur - last.value
+    switch {
+    case math.Abs(delta) <= tolerance:
+        delta = 0
+    case delta < 0:
+        // The counter has been reset, e.g. by a restart, and counted
+        // cur since.
+        delta = cur
+        fallthrough
+    default:
+        last.value = cur
+    }
+    last.seen = now
+    l.values[hash] = last
+    return delta
+}
+
+// exactDelta works like delta for the exact integer value cur, see
+// EsOpts.ExactIntegers. The difference is returned as a json.Number so that it
+// is marshaled without conversion to float64.
+func (l *lastValues) exactDelta(hash uint64, cur uint64, tolerance float64, now time.Time) json.Number {
+    l.mtx.Lock()
+    defer l.mtx.Unlock()
+
+    last := l.values[hash]
+    var delta int64
+    if cur < last.exact {
+        delta = int64(cur) // Reset, see delta.
+    } else {
+        delta = int64(cur - last.exact)
+    }
+    if math.Abs(float64(cur)-float64(last.exact)) > tolerance {
+        last.value = float64(cur)
+        last.exact = cur
+    } else {
+        delta = 0
+    }
+    last.seen = now
+    l.values[hash] = last
+    return json.Number(strconv.FormatInt(delta, 10))
+}
+
+// remaining returns the difference of cur to the last value l that has not
+// been pushed yet, or cur itself if the counter has been reset in between.
+// Unlike delta, it applies no tolerance, as no later push picks up the rest.
+func (l lastValue) remaining(cur float64) float64 {
+    if cur < l.value {
+        return cur
+    }
+    return cur - l.value
+}
+
+// remainingExact works like remaining for the exact integer value cur, see
+// exactDelta.
+func (l lastValue) remainingExact(cur uint64) json.Number {
+    if cur < l.exact {
+        return json.Number(strconv.FormatUint(cur, 10))
+    }
+    return json.Number(strconv.FormatUint(cur-l.exact, 10))
+}
+
+// restore makes the restored last value of the series with the given key, if
+// any, the last value of the series hash, unless that has one already.
+func (l *lastValues) restore(hash uint64, key string) {
+    l.mtx.Lock()
+    defer l.mtx.Unlock()
+
+    last, ok := l.restored[key]
+    if !ok {
+        return
+    }
+    delete(l.restored, key)
+    if _, ok := l.values[hash]; !ok {
+        l.values[hash] = last
+    }
+}
+
+// restoring reports whether any restored last values are left.
+func (l *lastValues) restoring() bool {
+    l.mtx.Lock()
+    defer l.mtx.Unlock()
+
+    return len(l.restored) > 0
+}
+
+// prune deletes the last values of all series not seen since before.
+func (l *lastValues) prune(before time.Time) {
+    l.mtx.Lock()
+    defer l.mtx.Unlock()
+
+    for hash, last := range l.values {
+        if last.seen.Before(before) {
+            delete(l.values, hash)
+        }
+    }
+    for key, last := range l.restored {
+        if last.seen.Before(before) {
+            delete(l.restored, key)
+        }
+    }
+}
+
+// delete deletes the last value of the series hash.
+func (l *lastValues) delete(hash uint64) {
+    l.mtx.Lock()
+    defer l.mtx.Unlock()
+
+    delete(l.values, hash)
+}
+
+// get returns the last value of the series hash, the zero value if there is
+// none.
+func (l *lastValues) get(hash uint64) lastValue {
+    l.mtx.Lock()
+    defer l.mtx.Unlock()
+
+    return l.values[hash]
+}
+
+// len returns the number of series with a last value.
+func (l *lastValues) len() int {
+    l.mtx.Lock()
+    defer l.mtx.Unlock()
+
+    return len(l.values)
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// persistedValue is a lastValue as stored in EsOpts.LastValueFile.
+type persistedValue struct {
+    Value float64   `json:"value"`
+    Exact uint64    `json:"exact,omitempty"`
+    Seen  time.Time `json:"seen"`
+}
+
+// lastValueFile is the content of EsOpts.LastValueFile. It holds the last
+// values per fully-qualified metric name and series key (see seriesKey).
+type lastValueFile map[string]map[string]persistedValue
+
+// lastValueFileMtx serializes the access to the files of EsOpts.LastValueFile,
+// which may be shared by several metric vectors.
+var lastValueFileMtx sync.Mutex
+
+// readLastValueFile reads the file at path. A missing file yields no last
+// values.
+func readLastValueFile(path string) (lastValueFile, error) {
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return lastValueFile{}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    file := lastValueFile{}
+    if err := json.Unmarshal(data, &file); err != nil {
+        return nil, err
+    }
+    return file, nil
+}
+
+// loadLastValues reads the last values of the metric fqName from the file at
+// path, keyed by series key.
+func loadLastValues(path, fqName string) (map[string]lastValue, error) {
+    lastValueFileMtx.Lock()
+    file, err := readLastValueFile(path)
+    lastValueFileMtx.Unlock()
+    if err != nil {
+        return nil, err
+    }
+    restored := make(map[string]lastValue, len(file[fqName]))
+    for key, v := range file[fqName] {
+        restored[key] = lastValue{value: v.Value, exact: v.Exact, seen: v.Seen}
+    }
+    return restored, nil
+}
+
+// SaveLastValues writes the last pushed values of the counters in this vector
+// to EsOpts.LastValueFile, so that a later process can continue computing
+// their deltas, e.g. on shutdown. The file is replaced atomically. The values
+// of other metric vectors in the same file are kept. SaveLastValues is a no-op
+// if EsOpts.LastValueFile is not set.
+func (m *metricMap) SaveLastValues() error {
+    path := m.esOpts.LastValueFile
+    if path == "" {
+        return nil
+    }
+    saved := m.persistedValues()
+
+    lastValueFileMtx.Lock()
+    defer lastValueFileMtx.Unlock()
+    file, err := readLastValueFile(path)
+    if err != nil {
+        // Do not let a corrupt file prevent saving.
+        file = lastValueFile{}
+    }
+    if len(saved) > 0 {
+        file[m.desc.fqName] = saved
+    } else {
+        delete(file, m.desc.fqName)
+    }
+    data, err := json.Marshal(file)
+    if err != nil {
+        return err
+    }
+    tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+// persistedValues returns the last values of all series, keyed by series key,
+// including the restored values of series that have not been pushed since.
+func (m *metricMap) persistedValues() map[string]persistedValue {
+    m.mtx.RLock()
+    defer m.mtx.RUnlock()
+    m.lastValues.mtx.Lock()
+    defer m.lastValues.mtx.Unlock()
+
+    saved := make(map[string]persistedValue, len(m.lastValues.values)+len(m.lastValues.restored))
+    for key, v := range m.lastValues.restored {
+        saved[key] = persistedValue{Value: v.value, Exact: v.exact, Seen: v.seen}
+    }
+    for hash, lvsSlice := range m.metrics {
+        v, ok := m.lastValues.values[hash]
+        if !ok {
+            continue
+        }
+        for _, lvs := range lvsSlice {
+            saved[m.seriesKey(lvs.values)] = persistedValue{Value: v.value, Exact: v.exact, Seen: v.seen}
+        }
+    }
+    return saved
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+)
+
+func TestLastValueFile(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    path := filepath.Join(t.TempDir(), "last_values.json")
+    esOpts := EsOpts{LastValueFile: path}
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", esOpts, "file_code")
+    vec.WithLabelValues("saved").Add(10)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if err := vec.SaveLastValues(); err != nil {
+        t.Fatal(err)
+    }
+
+    // A new process continues with the saved last values, e.g. of a
+    // counter mirroring an external total.
+    restarted := newTestCounterVec(server.URL+"/metrics/doc/", esOpts, "file_code")
+    restarted.WithLabelValues("saved").Add(30)
+    restarted.WithLabelValues("unsaved").Add(5)
+    restarted.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    values := map[string]float64{}
+    for _, doc := range server.docs(t)[1:] {
+        values[doc["file_code"].(string)] = doc[VALUE].(float64)
+    }
+    if values["saved"] != 20 || values["unsaved"] != 5 {
+        t.Errorf("got values %v after restart, want saved 20 and unsaved 5", values)
+    }
+
+    // A corrupt file is ignored.
+    if err := ioutil.WriteFile(path, []byte("{corrupt"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    server.mtx.Lock()
+    server.requests = nil
+    server.mtx.Unlock()
+    corrupt := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        LastValueFile: path,
+        Logger:        &capturingLogger{},
+    }, "file_code")
+    corrupt.WithLabelValues("saved").Add(30)
+    corrupt.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if docs := server.docs(t); len(docs) != 1 || docs[0][VALUE] != 30. {
+        t.Errorf("got documents %v with a corrupt file, want the total value 30", docs)
+    }
+    if err := corrupt.SaveLastValues(); err != nil {
+        t.Errorf("saving over a corrupt file: %v", err)
+    }
+}
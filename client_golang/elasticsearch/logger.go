@@ -0,0 +1,76 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "fmt"
+    "log"
+)
+
+// Logger receives the warnings and errors of the pushes to Elasticsearch. It
+// is satisfied by seelog.LoggerInterface, see the package seelogger. Other
+// logging libraries can be plugged in with a small adapter. The returned
+// errors are ignored.
+type Logger interface {
+    Warn(v ...interface{}) error
+    Warnf(format string, params ...interface{}) error
+    Error(v ...interface{}) error
+    Errorf(format string, params ...interface{}) error
+}
+
+// DiscardLogger is a Logger discarding everything, e.g. to silence the pushes
+// of a metric vector with EsOpts.Logger.
+var DiscardLogger Logger = discardLogger{}
+
+type discardLogger struct{}
+
+func (discardLogger) Warn(...interface{}) error           { return nil }
+func (discardLogger) Warnf(string, ...interface{}) error  { return nil }
+func (discardLogger) Error(...interface{}) error          { return nil }
+func (discardLogger) Errorf(string, ...interface{}) error { return nil }
+
+// stdLogger writes to the standard logger of the log package, prefixing the
+// messages with their level and the metric family fqName.
+type stdLogger struct {
+    fqName string
+}
+
+func (l stdLogger) Warn(v ...interface{}) error {
+    return l.output("WARN", fmt.Sprint(v...))
+}
+
+func (l stdLogger) Warnf(format string, params ...interface{}) error {
+    return l.output("WARN", fmt.Sprintf(format, params...))
+}
+
+func (l stdLogger) Error(v ...interface{}) error {
+    return l.output("ERROR", fmt.Sprint(v...))
+}
+
+func (l stdLogger) Errorf(format string, params ...interface{}) error {
+    return l.output("ERROR", fmt.Sprintf(format, params...))
+}
+
+func (l stdLogger) output(level, msg string) error {
+    return log.Output(3, fmt.Sprintf("[%s] %s: %s", level, l.fqName, msg))
+}
+
+// newLogger returns EsOpts.Logger or, if that is not set, a Logger writing to
+// the standard logger of the log package.
+func newLogger(fqName string, esOpts EsOpts) Logger {
+    if esOpts.Logger != nil {
+        return esOpts.Logger
+    }
+    return stdLogger{fqName: fqName}
+}
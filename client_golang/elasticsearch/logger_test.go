@@ -0,0 +1,89 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "bytes"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// capturingLogger records the warnings and errors logged to it.
+type capturingLogger struct {
+    mtx      sync.Mutex
+    messages []string
+}
+
+func (l *capturingLogger) record(msg string) error {
+    l.mtx.Lock()
+    defer l.mtx.Unlock()
+    l.messages = append(l.messages, msg)
+    return nil
+}
+
+func (l *capturingLogger) Warn(v ...interface{}) error {
+    return l.record("WARN " + fmt.Sprint(v...))
+}
+
+func (l *capturingLogger) Warnf(format string, params ...interface{}) error {
+    return l.record("WARN " + fmt.Sprintf(format, params...))
+}
+
+func (l *capturingLogger) Error(v ...interface{}) error {
+    return l.record("ERROR " + fmt.Sprint(v...))
+}
+
+func (l *capturingLogger) Errorf(format string, params ...interface{}) error {
+    return l.record("ERROR " + fmt.Sprintf(format, params...))
+}
+
+func TestLogger(t *testing.T) {
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusBadRequest)
+    })
+    defer server.Close()
+
+    logger := &capturingLogger{}
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{PerDocument: true, Logger: logger})
+    vec.WithLabelValues().Inc()
+    vec.pushDocToEs(COUNTER_TYPE, vec.logger())
+
+    logger.mtx.Lock()
+    defer logger.mtx.Unlock()
+    if len(logger.messages) != 1 || !strings.HasPrefix(logger.messages[0], "WARN ") ||
+        !strings.Contains(logger.messages[0], "400") {
+        t.Errorf("got messages %q, want a single warning about the rejected document", logger.messages)
+    }
+}
+
+func TestDefaultLogger(t *testing.T) {
+    var buf bytes.Buffer
+    log.SetOutput(&buf)
+    defer log.SetOutput(os.Stderr)
+
+    logger := newLogger("test_counter", EsOpts{})
+    logger.Warnf("document %d rejected", 1)
+    logger.Error("cluster unreachable")
+
+    got := buf.String()
+    if !strings.Contains(got, "[WARN] test_counter: document 1 rejected") ||
+        !strings.Contains(got, "[ERROR] test_counter: cluster unreachable") {
+        t.Errorf("got log output %q", got)
+    }
+}
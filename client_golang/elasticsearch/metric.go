@@ -14,11 +14,12 @@
 package elasticsearch
 
 import (
-    "fmt"
+    "crypto/tls"
+    "io"
+    "net/http"
     "time"
     "strings"
 
-    "github.com/cihub/seelog"
     "github.com/golang/protobuf/proto"
 
     dto "github.com/Schneizelw/elasticsearch/client_model/go"
@@ -99,28 +100,691 @@ type EsOpts struct {
     Port string
     EsIndex string
     EsType string
+    // Interval is the number of seconds between two pushes of the vector
+    // from a goroutine of its own. If it is zero or negative, the vector is
+    // only pushed explicitly, which is how vectors pushed by a Pusher are
+    // meant to be created.
     Interval int
+
+    // IdLabel names a variable label whose value is used as the document
+    // _id. Re-pushes of the same series then overwrite the same document,
+    // which turns the index into a "current state" view. Series lacking
+    // the label (or with an empty value) fall back to a time-based _id.
+    IdLabel string
+
+    // RoutingLabel names a variable label whose value is used as the
+    // routing key of the documents, so that all series sharing that value
+    // are stored on the same shard. Series with an empty value are not
+    // routed. The zero value does not route any documents.
+    RoutingLabel string
+
+    // MinPushInterval is the minimum time between two pushes of this
+    // metric family. Pushes arriving sooner are skipped, not queued. The
+    // zero value disables the limit.
+    MinPushInterval time.Duration
+
+    // DocumentValidator, if set, is called for every document right before
+    // it is sent. If it returns an error, the document is dropped, the
+    // error is logged, and es_push_dropped_documents_total is incremented
+    // (see NewPushCollector).
+    DocumentValidator func(doc map[string]interface{}) error
+
+    // SummaryLongFormat makes summaries emit one document per quantile,
+    // carrying the quantile in the Quantile field and its value in the
+    // Value field, instead of a single document with a field per
+    // quantile, named QUANTILE_ followed by the quantile as a percentage,
+    // e.g. QUANTILE_50 and QUANTILE_99_9 for 0.5 and 0.999.
+    SummaryLongFormat bool
+
+    // SummaryLayout selects where the sum, count, and quantiles of
+    // summaries are put in the documents. It has no effect if
+    // SummaryLongFormat is set.
+    SummaryLayout SummaryLayout
+
+    // HistogramLongFormat makes histograms emit one document per bucket,
+    // carrying the upper bound in the Le field and the cumulative count in
+    // the Count field, instead of a single document with a nested Buckets
+    // array.
+    HistogramLongFormat bool
+
+    // ClusterName, if set, is the expected cluster_name reported by the
+    // root endpoint of the cluster. It is verified before the first push,
+    // or earlier by Verify. As long as the verification fails, nothing is
+    // pushed. This guards against writing to the wrong cluster by
+    // misconfiguration.
+    ClusterName string
+
+    // PingBeforePush pings the cluster (see CounterVec.Ping etc.) before
+    // each push and skips the push if the cluster is unreachable or red,
+    // logging a single error instead of one per document.
+    PingBeforePush bool
+
+    // MarshalErrorPolicy determines what happens to documents that cannot
+    // be marshaled to JSON. In any case, es_push_marshal_errors_total is
+    // incremented (see NewPushCollector).
+    MarshalErrorPolicy MarshalErrorPolicy
+
+    // NonFiniteValues determines how NaN, +Inf, and -Inf values, which
+    // JSON cannot represent, are written. By default, the fields holding
+    // them are omitted from the documents.
+    NonFiniteValues NonFiniteValuePolicy
+
+    // FanOut lists additional indices (on the same Host and with the same
+    // EsType) every document is written to. Failures to write to one index
+    // do not affect the others.
+    FanOut []FanOutIndex
+
+    // GaugeAggregates makes gauges track minimum, maximum, and average of
+    // all values they had between two pushes. Documents then carry them in
+    // the Min, Max, and Avg fields, and the current value in the Last
+    // field, in addition to the Value field. It only applies to GaugeVec.
+    GaugeAggregates bool
+
+    // BatchTimeout caps the total wall-clock time of a push cycle, i.e. of
+    // sending all documents of the metric family, including the requests
+    // preceding them, e.g. to verify the cluster (see ClusterName). Once
+    // exceeded, in-flight requests are cancelled and the remaining
+    // documents are not pushed. The zero value means no limit.
+    BatchTimeout time.Duration
+
+    // Timeout caps the time of each HTTP request to Elasticsearch, so that
+    // a slow or unresponsive node cannot stall the pushes. A request is
+    // also cancelled once the context of the push it belongs to is done,
+    // see BatchTimeout and ResetAndPush. The zero value means 10 seconds,
+    // a negative value means no timeout.
+    Timeout time.Duration
+
+    // Credentials is sent as the Authorization header with every request,
+    // e.g. "ApiKey <base64 id:key>" or "Basic <base64 user:password>".
+    Credentials string
+
+    // CredentialsProvider, if set, is called before every request to obtain
+    // the current value of the Authorization header, overriding
+    // Credentials. This supports short-lived, rotating tokens. Caching is
+    // up to the provider. If it returns an error, the request is not sent.
+    CredentialsProvider func() (string, error)
+
+    // ProxyURL is the URL of the proxy to send all requests through, e.g.
+    // "http://proxy.example.org:3128". It overrides the proxy settings
+    // from the environment (HTTP_PROXY etc.), which are used otherwise.
+    ProxyURL string
+
+    // TLSConfig configures the TLS connections to HTTPS endpoints (see
+    // URL), e.g. RootCAs for a private CA, Certificates for client
+    // certificate authentication, or InsecureSkipVerify. The zero value
+    // uses the system roots.
+    TLSConfig *tls.Config
+
+    // HTTPClient, if set, sends all requests of the metric vector as it
+    // is, e.g. one with a round tripper signing the requests for AWS
+    // OpenSearch or limiting the connections. It cannot be combined with
+    // ProxyURL, TLSConfig, or Timeout, which configure the client built
+    // otherwise, and doing so causes a panic.
+    HTTPClient *http.Client
+
+    // GeoPoint, if set, combines the values of two labels into a
+    // Location field suitable for the Elasticsearch geo_point type. Series
+    // whose labels are not valid coordinates are pushed without it.
+    GeoPoint *GeoPointLabels
+
+    // FieldPrefix is prepended to the names of all top-level fields of the
+    // documents, e.g. "metric_" turns Value into metric_Value and a label
+    // "code" into metric_code. This separates the metric fields from other
+    // data in a shared index. The @timestamp field (see EpochTimestamp) is
+    // not prefixed.
+    FieldPrefix string
+
+    // FqNamePrefix is prepended to the fully-qualified metric name in the
+    // FqName field of the documents, e.g. "orderservice_" to tell apart
+    // the metrics of several services in a shared index. Unlike with
+    // WrapRegistererWithPrefix, the name of the metric in this process,
+    // as collected and as matched by MetricAllowlist and MetricDenylist,
+    // is unchanged.
+    FqNamePrefix string
+
+    // FieldNames remaps the names of the fields Value, Sum, Count, Help,
+    // Type, Timestamp, and FqName, e.g. Value to "value" and FqName to
+    // "__name__". FieldPrefix is applied to the remapped names. The zero
+    // value keeps all names.
+    FieldNames FieldNames
+
+    // InstanceUUID adds an InstanceUUID field to every document. Its value
+    // is a random UUID generated once per process start. A change of the
+    // UUID between documents of the same series signals a restart, i.e. a
+    // discontinuity of counters, to downstream consumers.
+    InstanceUUID bool
+
+    // Async makes pushes return right away. The documents of each push
+    // are queued and sent by a separate goroutine. The size of the queue
+    // is exported as es_push_buffered_bytes (see NewPushCollector). The
+    // goroutine exits when the vector is stopped, see Stop.
+    Async bool
+
+    // MaxInFlightBytes caps the total size of the documents queued or in
+    // flight if Async is set. The documents of a push that would exceed
+    // the limit are dropped. The zero value means no limit.
+    MaxInFlightBytes int64
+
+    // FlushDocs and FlushInterval buffer the documents of several pushes
+    // to smooth the load on the cluster. The buffered documents are sent
+    // together once FlushDocs documents are buffered or FlushInterval has
+    // passed since the last flush, whichever comes first, and on Flush.
+    // If only FlushDocs is set, documents may stay buffered until further
+    // pushes or Flush. PushContext and ResetAndPush bypass the buffer. The
+    // zero values send the documents of each push right away.
+    FlushDocs     int
+    FlushInterval time.Duration
+
+    // PushFilter, if set, is called with every series on each push. Only
+    // the series for which it returns true are pushed, e.g. gauges above
+    // a threshold. The zero value pushes all series.
+    PushFilter func(dto.Metric) bool
+
+    // LabelValueMapper, if set, transforms the label values of the pushed
+    // documents, e.g. to map opaque IDs to human-readable names. It is
+    // called with the name and value of each label and returns the value
+    // to index, which should be the value itself if there is no mapping.
+    // The values of the in-memory metrics are not changed.
+    LabelValueMapper func(labelName, value string) string
+
+    // Headers are set on every push request, e.g. to route the writes to a
+    // specific thread pool of the cluster. The Content-Type header and the
+    // Authorization header set from the credentials cannot be overridden.
+    Headers map[string]string
+
+    // Gzip compresses the bodies of the push requests and sets the header
+    // Content-Encoding: gzip, trading CPU for network traffic, especially
+    // with bulk requests. Not every proxy in front of a cluster supports
+    // compressed requests, so the zero value sends them uncompressed.
+    Gzip bool
+
+    // EventTimeField, if set, names a field holding the logical time of the
+    // sample, i.e. its explicit timestamp (see NewMetricWithTimestamp) or
+    // else the time it was collected for the push.
+    EventTimeField string
+
+    // EpochTimestamp replaces the RFC3339 Timestamp field of the documents
+    // with the field @timestamp holding the same time as epoch milliseconds,
+    // which Kibana and dynamic mapping pick up as a date without a custom
+    // mapping. The zero value keeps the Timestamp field only.
+    EpochTimestamp bool
+
+    // LegacyTimestamp keeps the Timestamp field alongside @timestamp when
+    // EpochTimestamp is set, e.g. while dashboards are migrated. It has no
+    // effect otherwise.
+    LegacyTimestamp bool
+
+    // IngestTimeField, if set, names a field holding the time the document
+    // was built for the push. Together with EventTimeField, this allows to
+    // measure the ingestion lag.
+    IngestTimeField string
+
+    // MarkReset adds the field Reset, set to true, to the documents pushed
+    // by ResetAndPush, marking the final values before a reset.
+    MarkReset bool
+
+    // MaxRetries is the number of times a document is resent after a
+    // network error or a response with a retryable status code. The zero
+    // value means no retries. MaxRetries and RetryBackoff only apply if
+    // PerDocument is set, see BulkMaxRetries and BulkRetryBackoff for
+    // bulk requests.
+    MaxRetries int
+
+    // RetryBackoff is the delay before the first retry. It doubles with
+    // every further retry. A delay requested by the cluster with a
+    // Retry-After header, e.g. along with status 429 Too Many Requests,
+    // takes precedence.
+    RetryBackoff time.Duration
+
+    // RetryJitter randomizes each delay before a retry, including those of
+    // bulk requests, by up to the given fraction in either direction, e.g.
+    // 0.2 for up to 20% shorter or longer delays. It keeps pushers that
+    // failed at the same time from retrying in lockstep. The zero value
+    // means no jitter.
+    RetryJitter float64
+
+    // RetryableStatusCodes are the HTTP status codes of responses that are
+    // retried. Responses with other non-2xx status codes fail right away.
+    // If nil, 429 and all 5xx status codes are retried.
+    RetryableStatusCodes []int
+
+    // NDJSONWriter, if set, receives the documents instead of the cluster.
+    // They are written in the NDJSON format of the bulk API, so that the
+    // output can later be ingested with a _bulk request. The index and
+    // mapping type of the actions are taken from EsIndex and EsType, which
+    // are still required, as are Host and Port. An NDJSONWriter shared
+    // between metric vectors must be safe for concurrent use.
+    NDJSONWriter io.Writer
+
+    // DryRun builds the documents of every push but, instead of sending
+    // them, writes them to DryRunWriter, one line per document and index
+    // URL holding the URL with the _id appended and the document. If
+    // DryRunWriter is nil, the lines are logged, with the documents
+    // truncated to MaxBodyLogLength. No requests are sent to the cluster,
+    // i.e. ClusterName, IndexTemplate, SchemaStrict, and PingBeforePush
+    // have no effect. A DryRunWriter shared between metric vectors must be
+    // safe for concurrent use.
+    DryRun       bool
+    DryRunWriter io.Writer
+
+    // SeriesLimitPerLabel, if positive, limits the number of distinct
+    // values of each label. Creating a series with a new value for a label
+    // that already has that many values is handled like one beyond
+    // MaxSeries, and the error names the label.
+    SeriesLimitPerLabel int
+
+    // MaxSeries, if positive, limits the number of series of the metric
+    // vector, protecting the memory of the process and the cluster from
+    // an unbounded label. Creating a further series is handled as
+    // configured by MaxSeriesPolicy and counted once per series as
+    // es_push_dropped_series_total (see NewPushCollector).
+    MaxSeries       int
+    MaxSeriesPolicy MaxSeriesPolicy
+
+    // Bulk has no effect anymore. Each push sends all its documents with
+    // a single request to the bulk API per index unless PerDocument is
+    // set.
+    //
+    // Deprecated: Bulk requests are the default.
+    Bulk bool
+
+    // PerDocument makes each push send one PUT request per document to
+    // the index, instead of a single request to the bulk API per index.
+    // The requests are retried as configured by MaxRetries, RetryBackoff,
+    // and RetryableStatusCodes. In bulk requests, if only some of the
+    // documents fail, only those failed with a retryable status code are
+    // resent, subject to BulkMaxRetries. PerDocument is ignored in
+    // Serverless and BulkUpsert mode.
+    PerDocument bool
+
+    // PerDocumentWorkers is the number of requests sent concurrently in
+    // PerDocument mode. A push returns once all its requests are done.
+    // WriteAck may then be called concurrently, DeadLetterSink is not.
+    // The zero value sends the requests one after another.
+    PerDocumentWorkers int
+
+    // BulkMaxRetries and BulkRetryBackoff are the equivalents of
+    // MaxRetries and RetryBackoff for bulk requests, which are more
+    // expensive to resend.
+    BulkMaxRetries   int
+    BulkRetryBackoff time.Duration
+
+    // InstanceLabel adds the field instance to every document, identifying
+    // the pushing process. Its value is taken from the environment variable
+    // named by InstanceEnvVar, e.g. one set to the pod name, or else is the
+    // hostname. A variable label named instance takes precedence.
+    InstanceLabel  bool
+    InstanceEnvVar string
+
+    // IndexPerType routes the documents of each metric type to an index of
+    // its own to keep the mappings of the indices homogeneous. The indices
+    // are named by TypeIndices or, by default, EsIndex followed by
+    // "-counter", "-gauge", "-summary", "-histogram", or "-untyped".
+    IndexPerType bool
+
+    // TypeIndices maps metric types (COUNTER_TYPE, GAUGE_TYPE, ...) to the
+    // names of their indices if IndexPerType is set.
+    TypeIndices map[int]string
+
+    // IndexDatePattern, if set, replaces the index of the URL with the
+    // pattern expanded on every push with the current UTC date, e.g.
+    // "metrics-%Y.%m.%d" routes the documents into daily indices like
+    // "metrics-2024.01.15". The directives are %Y, %m, %d, and %H for the
+    // zero-padded year, month, day, and hour, and %% for a literal %. With
+    // IndexPerType, the type suffix is appended to the expanded pattern and
+    // the names in TypeIndices are expanded as well. An unknown directive
+    // causes a panic on creation of the metric vector.
+    IndexDatePattern string
+
+    // WriteAck, if set, is called for every document the cluster has
+    // acknowledged, with the sequence number and version it assigned.
+    WriteAck func(WriteAck)
+
+    // Tombstones makes Delete and DeleteLabelValues push a final document
+    // for the deleted series before it is removed, keeping a record of it.
+    // The document carries the last value, for counters the increment not
+    // pushed yet, and the field deleted_at. It is written to the primary
+    // index and all FanOut indexes. Reset does not push tombstones, see
+    // ResetAndPush instead.
+    Tombstones bool
+
+    // CardinalityDocument adds a meta-document to every push, holding the
+    // fully-qualified name and the current number of series of the metric
+    // family. Its Type field is "Cardinality".
+    CardinalityDocument bool
+
+    // ErrorLogFirst and ErrorLogEvery limit the failures logged per push,
+    // e.g. during an outage of the cluster. Only the first ErrorLogFirst
+    // failures are logged, then only every ErrorLogEvery-th failure (none
+    // if ErrorLogEvery is zero). The number of failures not logged is
+    // logged at the end of the push. If both are zero, all failures are
+    // logged.
+    ErrorLogFirst int
+    ErrorLogEvery int
+
+    // Logger, if set, receives the warnings and errors of the pushes. The
+    // zero value writes them to the standard logger of the log package.
+    // See also DiscardLogger and the package seelogger.
+    Logger Logger
+
+    // DeadLetterSink, if set, is called for every document that could not
+    // be written, after all retries, e.g. to store it for a later replay.
+    DeadLetterSink func(DeadLetter)
+
+    // SchemaStrict verifies the mapping of each index before the first
+    // push to it, turning mapping drift into an error: Timestamp must be
+    // mapped as date, Value (of counters and gauges) as double, and the
+    // labels as keyword. While the mapping of an index does not match, or
+    // cannot be retrieved, nothing is pushed and an error is logged.
+    SchemaStrict bool
+
+    // IndexTemplate, if set, is created before the first push unless a
+    // template of the same name already exists. It maps the fields of the
+    // documents explicitly, e.g. Value as double, so that dynamic mapping
+    // cannot infer long from an integral first value. While the template
+    // cannot be checked or created, nothing is pushed and an error is
+    // logged.
+    IndexTemplate *IndexTemplate
+
+    // Relabel is applied to the labels of every series before its document
+    // is built, in order. It works like relabel_configs of Prometheus, see
+    // RelabelConfig. Series dropped by it are not pushed.
+    Relabel []RelabelConfig
+
+    // Serverless restricts the requests to those supported by serverless
+    // Elasticsearch: All documents are written to data streams, i.e. with
+    // bulk requests (regardless of PerDocument) of create actions without
+    // mapping types, and the credentials must be an API key, i.e. start
+    // with "ApiKey ". EpochTimestamp must be set, as data streams reject
+    // documents without @timestamp. Creating a metric vector with options
+    // not supported in serverless mode, like IdLabel, which would make
+    // repeated pushes collide, panics.
+    Serverless bool
+
+    // EnvelopeVersion selects the layout of the documents. It allows to
+    // migrate from one layout to another with metric vectors of both.
+    EnvelopeVersion EnvelopeVersion
+
+    // MaxBodyLogLength limits the number of bytes logged of a document
+    // body, e.g. along with a failed push of the document. Truncation is
+    // indicated by the number of bytes left out. The zero value means 1024
+    // bytes, a negative value means no limit.
+    MaxBodyLogLength int
+
+    // CounterFloatTolerance is the magnitude up to which the delta of a
+    // counter since the last push is indexed as zero, e.g. 1e-9 to hide
+    // the representation error of float-backed counters. The zero value
+    // indexes all deltas as they are.
+    CounterFloatTolerance float64
+
+    // CounterMode selects whether counters push the increase since their
+    // last push or their total value.
+    CounterMode CounterMode
+
+    // LastValueTTL is the time after which the last pushed value of a
+    // counter series that has not been pushed since is forgotten. The
+    // last values are needed to index the deltas and are pruned on each
+    // push. The TTL should exceed Interval by far, a series pushed again
+    // after its last value was pruned is indexed with its total value. The
+    // number of last values is exported as es_push_last_values (see
+    // NewPushCollector). The zero value keeps the last values forever.
+    LastValueTTL time.Duration
+
+    // LastValueFile is the path of a file to save the last pushed values
+    // of counters to with SaveLastValues, e.g. on shutdown, and to read
+    // them from when the metric vector is created, so that the first
+    // deltas after a restart are not the total values. The file may be
+    // shared by several metric vectors. A missing file is ignored, an
+    // unreadable one is logged. Either way, the deltas start over as if
+    // LastValueFile was not set. Pusher.Stop saves the last values. The
+    // zero value keeps the last values in memory only.
+    LastValueFile string
+
+    // BulkPath is the path of the bulk endpoint relative to the root of
+    // the cluster, used unless PerDocument is set. The placeholder
+    // "{index}" is replaced by the index name, e.g. "{index}/_bulk" for
+    // the index-level endpoint. A custom path allows for proxies that
+    // expose the bulk API elsewhere. The zero value means "_bulk", the
+    // cluster-level endpoint.
+    BulkPath string
+
+    // Pipeline names an ingest pipeline that preprocesses the pushed
+    // documents, e.g. to enrich them. It is passed as the "pipeline" query
+    // parameter of per-document requests and in the action metadata of
+    // bulk requests. It is not supported with BulkUpsert, as update
+    // actions do not run ingest pipelines. The zero value indexes the
+    // documents as is, unless the index has a default pipeline.
+    Pipeline string
+
+    // ValueScale multiplies the pushed values, e.g. 1e-9 to push durations
+    // observed in nanoseconds as seconds. It applies to the values of
+    // counters, gauges and untyped metrics, including the GaugeAggregates,
+    // and to the sums, quantiles and bucket upper bounds of summaries and
+    // histograms, but not to sample counts. PushFilter sees the unscaled values. The zero
+    // value means 1, i.e. no scaling.
+    ValueScale float64
+
+    // MetricAllowlist and MetricDenylist select the metric families to
+    // push by regular expressions matched against the entire
+    // fully-qualified name, e.g. "http_.*". A family is pushed if it does
+    // not match any pattern of MetricDenylist and, unless MetricAllowlist
+    // is empty, matches one of MetricAllowlist. The metrics of families
+    // not pushed are still collected. Invalid patterns cause a panic when
+    // the metric vector is created.
+    MetricAllowlist []string
+    MetricDenylist  []string
+
+    // PercentileHints are written as the percentile_hints array into the
+    // documents of histograms, e.g. []float64{50, 99}. They tell a
+    // downstream transform or ingest pipeline which percentiles to compute
+    // from the buckets. The zero value writes no hints.
+    PercentileHints []float64
+
+    // ConnectionWarmup makes the creation of the metric vector send a HEAD
+    // request to the root endpoint of each cluster pushed to, in the
+    // background. The first push then finds an open connection rather
+    // than paying for the TCP and TLS setup. It has no effect with DryRun
+    // or NDJSONWriter.
+    ConnectionWarmup bool
+
+    // MetricExpiry stops pushing series whose value has not changed for
+    // longer than MetricExpiry, as observed on push, until it changes
+    // again. Unlike Delete, the series remain in memory and are still
+    // collected. The zero value pushes all series.
+    MetricExpiry time.Duration
+
+    // BulkUpsert makes each push update the documents of its series, or
+    // create them if absent, with update actions with doc_as_upsert in
+    // requests to the bulk API, regardless of PerDocument. The indices
+    // then hold the latest values per series. It requires IdLabel to
+    // identify the documents and is not supported in Serverless mode.
+    BulkUpsert bool
+
+    // SampleCallback, if set, is called with every series on each push
+    // before it is turned into a document, e.g. to inspect bucket counts
+    // or exemplars for debugging. It gets the fully-qualified name, the
+    // labels as pushed, and a copy of the metric. It does not affect the
+    // push. Calls for different metric vectors may happen concurrently.
+    SampleCallback func(fqName string, labels map[string]string, m dto.Metric)
+
+    // URL is the index URL to push to, e.g.
+    // "https://security-es:9243/audit/_doc/", overriding Host, Port,
+    // EsIndex, and EsType, which only allow for plain HTTP. The URL of
+    // each metric vector may point to a different cluster. The fan-out
+    // indices are on the same cluster. As the _id of a document is
+    // appended to the URL, it must end with a "/". An invalid URL causes a
+    // panic when the metric vector is created, as do invalid Host and
+    // Port.
+    URL string
+
+    // FailoverURLs are the root URLs of further nodes of the same cluster,
+    // e.g. "http://es-2:9200/". A request that fails because the node it
+    // is sent to cannot be reached or responds with status 502, 503, or
+    // 504 is sent to the next node, the node of URL (or Host and Port)
+    // being the first. Each request starts with the node that last
+    // responded. If all nodes fail, an error listing each of their
+    // failures is returned. An invalid URL causes a panic when the metric
+    // vector is created. The zero value sends all requests to the node of
+    // URL.
+    FailoverURLs []string
+
+    // ExactIntegers makes counters whose value is an integer push their
+    // deltas, or totals with CounterCumulative, as exact integers.
+    // Otherwise, values are converted to float64 and lose precision beyond
+    // 2^53. A counter keeps its value as an integer as long as it has only
+    // been incremented by integers. It does not apply along with
+    // ValueScale. Sample counts of summaries and histograms are always
+    // pushed as exact integers.
+    ExactIntegers bool
+
+    // BatchCallback, if set, is called with the documents of every push
+    // and the index URLs they are to be written to, right before they are
+    // sent or written to NDJSONWriter, e.g. to keep an audit log of
+    // everything pushed. If it returns an error, the push is aborted and
+    // the error logged. The documents must not be modified.
+    BatchCallback func(urls []string, docs []BatchDocument) error
 }
 
-func SetLog(logFileName string) seelog.LoggerInterface {
-    logConfigStr := `
-        <seelog levels="info,warn">
-            <outputs formatid="runtime">
-                <buffered size="10000" flushperiod="1000">
-                    <rollingfile type="date" filename="./.metricWarnLog/metricLog_%v.log" datepattern="2006-01-02-15" maxrolls="120"/> 
-                </buffered>
-            </outputs>
-            <formats>
-                <format id="runtime" format="%%Date %%Time [%%LEVEL] %%Msg%%n"/>
-            </formats>
-        </seelog>
-    `
-    logConfigStr = fmt.Sprintf(logConfigStr, logFileName)
-    logger, err := seelog.LoggerFromConfigAsString(logConfigStr)
-    if err != nil {
-        panic(err)
+// EnvelopeVersion is a layout of the pushed documents. See
+// EsOpts.EnvelopeVersion.
+type EnvelopeVersion int
+
+const (
+    // EnvelopeFlat puts the labels at the top level of the documents,
+    // along with the other fields. This is the default. A label named like
+    // one of the other fields, e.g. Value, is indexed with the prefix
+    // "label_" instead, and a warning is logged.
+    EnvelopeFlat EnvelopeVersion = iota
+    // EnvelopeNested puts the labels into the object Labels, separating
+    // them from the other fields.
+    EnvelopeNested
+)
+
+// CounterMode is the kind of the values pushed for counters. See
+// EsOpts.CounterMode.
+type CounterMode int
+
+const (
+    // CounterDelta pushes the increase of each counter series since its
+    // last push, as configured by CounterFloatTolerance and LastValueTTL.
+    // This is the default.
+    CounterDelta CounterMode = iota
+    // CounterCumulative pushes the total value of each counter series,
+    // e.g. to compute rates with the derivative aggregation. The last
+    // pushed values are not kept.
+    CounterCumulative
+)
+
+// SummaryLayout is a layout of the fields of summaries. See
+// EsOpts.SummaryLayout.
+type SummaryLayout int
+
+const (
+    // SummaryFlat puts the Sum, Count, and QUANTILE_ fields of summaries
+    // at the top level of the documents, along with the other fields.
+    // This is the default.
+    SummaryFlat SummaryLayout = iota
+    // SummaryStructured puts them into the object Summary instead, e.g.
+    // Summary.Sum, Summary.Count, and Summary.QUANTILE_90, so that they
+    // are distinguished from the fields of other metric types and cannot
+    // collide with labels. EsOpts.FieldNames does not apply to them.
+    SummaryStructured
+)
+
+// DeadLetter is a document that could not be written. See
+// EsOpts.DeadLetterSink.
+type DeadLetter struct {
+    URL string // Index URL the document was to be written to.
+    ID  string
+    Doc []byte // The JSON document.
+    Err error  // Error of the last attempt.
+}
+
+// BatchDocument is a document of a push. See EsOpts.BatchCallback.
+type BatchDocument struct {
+    ID  string
+    Doc []byte // The JSON document.
+}
+
+// WriteAck describes a document written by the cluster. See EsOpts.WriteAck.
+type WriteAck struct {
+    Index       string `json:"_index"`
+    ID          string `json:"_id"`
+    Version     int64  `json:"_version"`
+    SeqNo       int64  `json:"_seq_no"`
+    PrimaryTerm int64  `json:"_primary_term"`
+}
+
+// IndexTemplate configures the index template created before the first push.
+// See EsOpts.IndexTemplate.
+type IndexTemplate struct {
+    // Name is the name of the template. It is required.
+    Name string
+    // Patterns are the index patterns the template applies to. By default,
+    // it applies to the index pushed to and all indices starting with its
+    // name, e.g. the per-type indices (see EsOpts.IndexPerType), or to those
+    // matching EsOpts.IndexDatePattern.
+    Patterns []string
+    // Settings are the index settings of the template, e.g.
+    // {"number_of_shards": 1}.
+    Settings map[string]interface{}
+}
+
+// GeoPointLabels names the labels holding latitude and longitude of a series.
+// See EsOpts.GeoPoint.
+type GeoPointLabels struct {
+    LatLabel, LonLabel string
+    // DropLabels removes the two labels from the document once they are
+    // combined into the Location field.
+    DropLabels bool
+}
+
+// FanOutIndex is an additional index documents are written to. See
+// EsOpts.FanOut.
+type FanOutIndex struct {
+    // Index is the name of the index.
+    Index string
+    // Every, if greater than 1, writes only the documents of every
+    // Every-th push cycle to the index, resulting in a downsampled copy,
+    // e.g. for long-term retention.
+    Every int
+}
+
+// MaxSeriesPolicy determines the handling of series to be created beyond
+// EsOpts.MaxSeries or EsOpts.SeriesLimitPerLabel.
+type MaxSeriesPolicy int
+
+const (
+    // RejectSeries fails creating the series with an error, i.e.
+    // GetMetricWithLabelValues and GetMetricWith return the error while
+    // WithLabelValues and With panic. This is the default.
+    RejectSeries MaxSeriesPolicy = iota
+    // DropSeries returns a metric that is neither collected nor pushed,
+    // so that its updates are lost without affecting the caller.
+    DropSeries
+)
+
+// MarshalErrorPolicy determines the handling of documents that cannot be
+// marshaled to JSON.
+type MarshalErrorPolicy int
+
+const (
+    // DiscardAndCount silently drops the document. This is the default.
+    DiscardAndCount MarshalErrorPolicy = iota
+    // DiscardAndLog drops the document and logs the error.
+    DiscardAndLog
+    // FailCycle logs the error and aborts the current push cycle, i.e. the
+    // remaining documents of the metric family are not pushed either.
+    FailCycle
+)
+
+// indexURL returns the index URL to push to as configured by esOpts, i.e.
+// EsOpts.URL or else the URL built by BuildEsUrl.
+func indexURL(esOpts EsOpts) string {
+    if esOpts.URL != "" {
+        return esOpts.URL
     }
-    return logger
+    return BuildEsUrl(esOpts.Host, esOpts.Port, esOpts.EsIndex, esOpts.EsType)
 }
 
 func BuildEsUrl(host, port, esIndex, esType string) string {
@@ -0,0 +1,70 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "math"
+)
+
+// NonFiniteValuePolicy determines the handling of NaN, +Inf, and -Inf values,
+// which cannot be marshaled to JSON and would otherwise cause the whole
+// document to be dropped.
+type NonFiniteValuePolicy int
+
+const (
+    // OmitNonFinite removes the fields holding non-finite values from the
+    // document. This is the default.
+    OmitNonFinite NonFiniteValuePolicy = iota
+    // NullNonFinite writes non-finite values as null.
+    NullNonFinite
+    // StringNonFinite writes non-finite values as the strings "NaN",
+    // "+Inf", and "-Inf". Note that Elasticsearch rejects them in fields
+    // mapped as numbers.
+    StringNonFinite
+)
+
+// sanitizeFloats replaces the non-finite float64 values of the top-level fields
+// of doc as determined by policy.
+func sanitizeFloats(doc map[string]interface{}, policy NonFiniteValuePolicy) {
+    for k, v := range doc {
+        if object, ok := v.(map[string]interface{}); ok {
+            sanitizeFloats(object, policy)
+            continue
+        }
+        f, ok := v.(float64)
+        if !ok || !(math.IsNaN(f) || math.IsInf(f, 0)) {
+            continue
+        }
+        switch policy {
+        case NullNonFinite:
+            doc[k] = nil
+        case StringNonFinite:
+            doc[k] = nonFiniteString(f)
+        default:
+            delete(doc, k)
+        }
+    }
+}
+
+// nonFiniteString returns the representation of the non-finite f used by the
+// Prometheus text format.
+func nonFiniteString(f float64) string {
+    switch {
+    case math.IsInf(f, +1):
+        return "+Inf"
+    case math.IsInf(f, -1):
+        return "-Inf"
+    }
+    return "NaN"
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "bytes"
+    "encoding/json"
+    "math"
+    "testing"
+)
+
+func TestNonFiniteValues(t *testing.T) {
+    types := map[int]ValueType{COUNTER_TYPE: CounterValue, GAUGE_TYPE: GaugeValue}
+    values := map[string]float64{"NaN": math.NaN(), "+Inf": math.Inf(+1), "-Inf": math.Inf(-1)}
+    for metricType, valueType := range types {
+        for name, value := range values {
+            for _, policy := range []NonFiniteValuePolicy{OmitNonFinite, NullNonFinite, StringNonFinite} {
+                var buf bytes.Buffer
+                desc := NewDesc("test_nonfinite", "helpless", nil, nil)
+                vec := newMetricVec(desc, "http://localhost:9200/metrics/doc/", EsOpts{
+                    NDJSONWriter:    &buf,
+                    NonFiniteValues: policy,
+                }, func(lvs ...string) Metric {
+                    return MustNewConstMetric(desc, valueType, value, lvs...)
+                })
+                vec.getMetricWithLabelValues()
+                vec.pushDocToEs(metricType, DiscardLogger)
+
+                lines := bulkLines(buf.Bytes())
+                if len(lines) != 2 {
+                    t.Errorf("type %d, %s, policy %d: got %d lines, want 2", metricType, name, policy, len(lines))
+                    continue
+                }
+                doc := map[string]interface{}{}
+                if err := json.Unmarshal(lines[1], &doc); err != nil {
+                    t.Fatal(err)
+                }
+                got, ok := doc[VALUE]
+                switch policy {
+                case OmitNonFinite:
+                    if ok {
+                        t.Errorf("type %d, %s: got value %v, want none", metricType, name, got)
+                    }
+                case NullNonFinite:
+                    if !ok || got != nil {
+                        t.Errorf("type %d, %s: got value %v, want null", metricType, name, got)
+                    }
+                case StringNonFinite:
+                    if got != name {
+                        t.Errorf("type %d, %s: got value %v, want %q", metricType, name, got, name)
+                    }
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "sort"
+)
+
+// esPusher is implemented by the metric vectors of this package.
+type esPusher interface {
+    // metricType returns COUNTER_TYPE, GAUGE_TYPE, ...
+    metricType() int
+    // push pushes the metrics of the vector right away.
+    push()
+    // drain sends the buffered documents and waits until the
+    // asynchronously pushed documents are sent.
+    drain()
+    // Stop ends the periodic pushes of the vector.
+    Stop()
+}
+
+// Push pushes the metrics of all registered metric vectors of this package
+// (CounterVec, GaugeVec, SummaryVec, HistogramVec) right away, in addition to
+// their periodic pushes. The vectors are pushed one after another. Those of
+// the metric types listed in order (COUNTER_TYPE, GAUGE_TYPE, ...) are pushed
+// first, type by type in the given order. The order among vectors of the same
+// type, and of those of unlisted types, is unspecified.
+//
+// Collectors registered through a wrapping Registerer (see
+// WrapRegistererWith) are not pushed.
+func (r *Registry) Push(order ...int) {
+    for _, p := range r.pushers(order) {
+        p.push()
+    }
+}
+
+// pushers returns the registered metric vectors of this package, sorted as
+// described for Push.
+func (r *Registry) pushers(order []int) []esPusher {
+    r.mtx.RLock()
+    var pushers []esPusher
+    for _, c := range r.collectorsByID {
+        if p, ok := c.(esPusher); ok {
+            pushers = append(pushers, p)
+        }
+    }
+    for _, c := range r.uncheckedCollectors {
+        if p, ok := c.(esPusher); ok {
+            pushers = append(pushers, p)
+        }
+    }
+    r.mtx.RUnlock()
+
+    rank := make(map[int]int, len(order))
+    for i, t := range order {
+        if _, ok := rank[t]; !ok {
+            rank[t] = i
+        }
+    }
+    rankOf := func(p esPusher) int {
+        if i, ok := rank[p.metricType()]; ok {
+            return i
+        }
+        return len(order)
+    }
+    sort.SliceStable(pushers, func(i, j int) bool {
+        return rankOf(pushers[i]) < rankOf(pushers[j])
+    })
+    return pushers
+}
+
+// drain sends the documents buffered as configured by EsOpts.FlushDocs and
+// EsOpts.FlushInterval and waits until the documents queued for asynchronous
+// sending (see EsOpts.Async), if any, have been sent or dropped. Then, it saves
+// the last values to EsOpts.LastValueFile, if set.
+func (m *metricVec) drain() {
+    m.Flush()
+    if m.buffer != nil {
+        m.buffer.wait()
+    }
+    if err := m.SaveLastValues(); err != nil {
+        m.logger().Errorf("saving last values of %s: %v", m.desc.fqName, err)
+    }
+}
+
+// Stop ends the periodic pushes of the vector (see EsOpts.Interval) and the
+// connection warm-up still in progress, and removes the metrics about its
+// pushes from the Collector returned by NewPushCollector. Documents queued for
+// asynchronous sending (see EsOpts.Async) are still sent, then the goroutine
+// sending them exits. Use it for a vector that is no longer used. Pushing the
+// vector explicitly remains possible, but the documents are then sent
+// synchronously. Stop is idempotent.
+func (m *metricMap) Stop() {
+    m.stopOnce.Do(func() {
+        close(m.stop)
+        if m.buffer != nil {
+            m.buffer.close()
+        }
+        m.pushMetrics.remove(m.desc.fqName)
+    })
+}
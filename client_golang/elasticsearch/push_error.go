@@ -0,0 +1,48 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "fmt"
+)
+
+// PushError is returned by a push of which some documents could not be
+// written. A document written to several indices (see EsOpts.FanOut) counts
+// once per index.
+type PushError struct {
+    FqName string // Name of the pushed metric family.
+    Failed int    // Number of documents that could not be written.
+    Total  int    // Number of documents of the push.
+    // Err is the first failure or, if the push was aborted, the error of
+    // its context, e.g. context.Canceled.
+    Err error
+}
+
+func (e *PushError) Error() string {
+    return fmt.Sprintf("pushing %s: %d of %d documents failed: %v", e.FqName, e.Failed, e.Total, e.Err)
+}
+
+// Unwrap returns Err.
+func (e *PushError) Unwrap() error {
+    return e.Err
+}
+
+// orNil returns e if any document failed, so that a successful push yields a
+// nil error interface rather than a nil *PushError.
+func (e *PushError) orNil() error {
+    if e.Failed == 0 && e.Err == nil {
+        return nil
+    }
+    return e
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "errors"
+    "net/http"
+    "path"
+    "strings"
+    "testing"
+)
+
+func TestPushError(t *testing.T) {
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        if strings.HasPrefix(path.Base(r.URL.Path), "bad") {
+            w.WriteHeader(http.StatusBadRequest)
+        }
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{PerDocument: true, IdLabel: "id"}, "id")
+    for _, id := range []string{"good1", "bad1", "good2", "bad2"} {
+        vec.WithLabelValues(id).Inc()
+    }
+    err := vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    var pushErr *PushError
+    if !errors.As(err, &pushErr) {
+        t.Fatalf("got error %v, want a *PushError", err)
+    }
+    if pushErr.Failed != 2 || pushErr.Total != 4 || pushErr.FqName != "test_counter" {
+        t.Errorf("got %d of %d documents of %s failed, want 2 of 4 of test_counter", pushErr.Failed, pushErr.Total, pushErr.FqName)
+    }
+    var statusErr *statusError
+    if !errors.As(err, &statusErr) || statusErr.code != http.StatusBadRequest {
+        t.Errorf("got first cause %v, want status 400", pushErr.Err)
+    }
+
+    for _, id := range []string{"bad1", "bad2"} {
+        vec.DeleteLabelValues(id)
+    }
+    if err := vec.pushDocToEs(COUNTER_TYPE, DiscardLogger); err != nil {
+        t.Errorf("unexpected error %v", err)
+    }
+}
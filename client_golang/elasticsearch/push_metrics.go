@@ -0,0 +1,144 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "sync"
+)
+
+// pushMetrics instruments the Elasticsearch push path of a single metric
+// family. All its metrics carry the fully-qualified name of that family as the
+// constant label "fq_name".
+type pushMetrics struct {
+    droppedDocs   Counter
+    droppedSeries Counter
+    marshalErrors Counter
+    bufferedBytes Gauge
+    batchDocs     Histogram
+    lastValues    GaugeFunc
+}
+
+// allPushMetrics tracks the pushMetrics of the metric vectors created so far
+// and not stopped, by fully-qualified name, so that they can be exported by
+// the Collector returned by NewPushCollector. Of several vectors with the same
+// name, only the pushMetrics of the last one created are exported, as they
+// would collide otherwise.
+var allPushMetrics struct {
+    mtx sync.Mutex
+    pms map[string][]*pushMetrics
+}
+
+func newPushMetrics(fqName string, lastValues *lastValues) *pushMetrics {
+    constLabels := Labels{"fq_name": fqName}
+    pm := &pushMetrics{
+        droppedDocs: NewCounter(CounterOpts{
+            Name:        "es_push_dropped_documents_total",
+            Help:        "Total number of documents dropped before being sent to Elasticsearch.",
+            ConstLabels: constLabels,
+        }),
+        droppedSeries: NewCounter(CounterOpts{
+            Name:        "es_push_dropped_series_total",
+            Help:        "Total number of series not created as the series limit was reached.",
+            ConstLabels: constLabels,
+        }),
+        marshalErrors: NewCounter(CounterOpts{
+            Name:        "es_push_marshal_errors_total",
+            Help:        "Total number of documents that could not be marshaled to JSON.",
+            ConstLabels: constLabels,
+        }),
+        bufferedBytes: NewGauge(GaugeOpts{
+            Name:        "es_push_buffered_bytes",
+            Help:        "Total size of the documents queued or in flight in the asynchronous buffer.",
+            ConstLabels: constLabels,
+        }),
+        batchDocs: NewHistogram(HistogramOpts{
+            Name:        "es_push_batch_documents",
+            Help:        "Number of documents per batch sent to Elasticsearch.",
+            ConstLabels: constLabels,
+            Buckets:     ExponentialBuckets(1, 2, 12),
+        }),
+        lastValues: NewGaugeFunc(GaugeOpts{
+            Name:        "es_push_last_values",
+            Help:        "Number of counter series whose last pushed value is kept to compute deltas.",
+            ConstLabels: constLabels,
+        }, func() float64 { return float64(lastValues.len()) }),
+    }
+
+    allPushMetrics.mtx.Lock()
+    if allPushMetrics.pms == nil {
+        allPushMetrics.pms = map[string][]*pushMetrics{}
+    }
+    allPushMetrics.pms[fqName] = append(allPushMetrics.pms[fqName], pm)
+    allPushMetrics.mtx.Unlock()
+    return pm
+}
+
+// remove stops exporting pm, the pushMetrics of the metric family fqName.
+func (pm *pushMetrics) remove(fqName string) {
+    allPushMetrics.mtx.Lock()
+    defer allPushMetrics.mtx.Unlock()
+
+    pms := allPushMetrics.pms[fqName]
+    for i, p := range pms {
+        if p == pm {
+            pms = append(pms[:i:i], pms[i+1:]...)
+            break
+        }
+    }
+    if len(pms) == 0 {
+        delete(allPushMetrics.pms, fqName)
+        return
+    }
+    allPushMetrics.pms[fqName] = pms
+}
+
+func (pm *pushMetrics) collect(ch chan<- Metric) {
+    pm.droppedDocs.Collect(ch)
+    pm.droppedSeries.Collect(ch)
+    pm.marshalErrors.Collect(ch)
+    pm.bufferedBytes.Collect(ch)
+    pm.batchDocs.Collect(ch)
+    pm.lastValues.Collect(ch)
+}
+
+type pushCollector struct{}
+
+// NewPushCollector returns a Collector exporting metrics about the pushes to
+// Elasticsearch performed by all metric vectors (CounterVec, GaugeVec, ...) of
+// this package, partitioned by the fully-qualified name of the pushed metric
+// family.
+//
+// As metric vectors may be created after the Collector has been registered,
+// the returned Collector is unchecked, i.e. its Describe method yields no
+// descriptors.
+func NewPushCollector() Collector {
+    return pushCollector{}
+}
+
+// Describe implements Collector.
+func (pushCollector) Describe(chan<- *Desc) {}
+
+// Collect implements Collector.
+func (pushCollector) Collect(ch chan<- Metric) {
+    allPushMetrics.mtx.Lock()
+    pms := make([]*pushMetrics, 0, len(allPushMetrics.pms))
+    for _, named := range allPushMetrics.pms {
+        pms = append(pms, named[len(named)-1])
+    }
+    allPushMetrics.mtx.Unlock()
+
+    for _, pm := range pms {
+        pm.collect(ch)
+    }
+}
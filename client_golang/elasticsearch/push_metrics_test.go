@@ -0,0 +1,48 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "testing"
+)
+
+func TestPushCollectorRecreatedVector(t *testing.T) {
+    reg := NewRegistry()
+    reg.MustRegister(NewPushCollector())
+
+    for i := 0; i < 2; i++ {
+        vec := newTestCounterVec("", EsOpts{DryRun: true}, "code")
+        vec.WithLabelValues("200").Inc()
+    }
+    mfs, err := reg.Gather()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var n int
+    for _, mf := range mfs {
+        if mf.GetName() != "es_push_dropped_documents_total" {
+            continue
+        }
+        for _, m := range mf.GetMetric() {
+            for _, lp := range m.GetLabel() {
+                if lp.GetName() == "fq_name" && lp.GetValue() == "test_counter" {
+                    n++
+                }
+            }
+        }
+    }
+    if n != 1 {
+        t.Errorf("got %d series for test_counter, want 1", n)
+    }
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "reflect"
+    "strconv"
+    "testing"
+    "time"
+)
+
+func TestRegistryPush(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    counters := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "push_order")
+    counters.log = DiscardLogger
+    counters.WithLabelValues("c").Inc()
+
+    desc := NewDesc("test_gauge", "helpless", []string{"push_order"}, nil)
+    gauges := &GaugeVec{newMetricVec(desc, server.URL+"/metrics/doc/", EsOpts{}, func(lvs ...string) Metric {
+        result := &gauge{desc: desc, labelPairs: makeLabelPairs(desc, lvs)}
+        result.init(result)
+        return result
+    })}
+    gauges.log = DiscardLogger
+    gauges.WithLabelValues("g").Set(1)
+
+    reg := NewRegistry()
+    reg.MustRegister(counters, gauges, NewCounter(CounterOpts{Name: "unpushed", Help: "Not a vector."}))
+
+    reg.Push(GAUGE_TYPE, COUNTER_TYPE)
+    reg.Push(COUNTER_TYPE)
+
+    var got []string
+    for _, doc := range server.docs(t) {
+        got = append(got, doc[FQNAME].(string))
+    }
+    want := []string{"test_gauge", "test_counter", "test_counter", "test_gauge"}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("got documents of %q, want %q", got, want)
+    }
+}
+
+func TestPushContextCancel(t *testing.T) {
+    received := make(chan struct{}, 1)
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        // Reading the body lets the server notice the client going away.
+        ioutil.ReadAll(r.Body)
+        received <- struct{}{}
+        select {
+        case <-r.Context().Done():
+        case <-time.After(10 * time.Second):
+        }
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{MaxRetries: 3, BulkMaxRetries: 3})
+    vec.log = DiscardLogger
+    vec.WithLabelValues().Inc()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    go func() {
+        <-received
+        cancel()
+    }()
+    start := time.Now()
+    err := vec.PushContext(ctx)
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("got error %v, want %v", err, context.Canceled)
+    }
+    if d := time.Since(start); d > 5*time.Second {
+        t.Errorf("push took %v after cancellation", d)
+    }
+}
+
+func TestPusher(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    counters := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{Async: true}, "pusher_code")
+    counters.log = DiscardLogger
+    counters.WithLabelValues("pusher").Inc()
+    reg := NewRegistry()
+    reg.MustRegister(counters)
+
+    const interval = 100 * time.Millisecond
+    p := NewPusher(reg, interval)
+    p.Start()
+    time.Sleep(2*interval + interval/2)
+    p.Stop()
+
+    if got := len(server.docs(t)); got != 2 {
+        t.Errorf("got %d documents after two intervals, want 2", got)
+    }
+    time.Sleep(2 * interval)
+    if got := len(server.docs(t)); got != 2 {
+        t.Errorf("got %d documents after Stop, want 2", got)
+    }
+}
+
+func TestPusherWithoutInterval(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    counters := NewCounterVec(CounterOpts{
+        Name: "test_pusher_total",
+        Help: "helpless",
+    }, CounterEsOpts{URL: server.URL + "/metrics/doc/"}, []string{"code"})
+    counters.WithLabelValues("200").Inc()
+    counters.WithLabelValues("500").Inc()
+    reg := NewRegistry()
+    reg.MustRegister(counters)
+
+    const interval = 100 * time.Millisecond
+    p := NewPusher(reg, interval)
+    p.Start()
+    time.Sleep(2*interval + interval/2)
+    p.Stop()
+
+    if got := len(server.docs(t)); got != 4 {
+        t.Errorf("got %d documents after two intervals, want one per series and interval, 4", got)
+    }
+}
+
+func TestPushWhileCreatingSeries(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "race_code")
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for i := 0; i < 200; i++ {
+            vec.WithLabelValues(strconv.Itoa(i)).Inc()
+        }
+    }()
+    for i := 0; i < 20; i++ {
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    }
+    <-done
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    ids := map[string]bool{}
+    for _, doc := range server.docs(t) {
+        ids[doc["race_code"].(string)] = true
+    }
+    if len(ids) != 200 {
+        t.Errorf("got documents of %d series, want 200", len(ids))
+    }
+}
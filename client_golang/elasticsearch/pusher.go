@@ -0,0 +1,85 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "sync"
+    "time"
+)
+
+// Pusher periodically pushes the metric vectors of this package registered
+// with a Registry (see Registry.Push) from a goroutine of its own, so that the
+// pushes neither depend on nor block the scrapes of the Registry. Create it
+// with NewPusher. The vectors should be created with an EsOpts.Interval of 0,
+// or they are pushed by their own goroutine in addition.
+type Pusher struct {
+    registry *Registry
+    interval time.Duration
+    order    []int
+
+    mtx  sync.Mutex
+    stop chan struct{} // Closed by Stop, nil if not running.
+    done chan struct{} // Closed once the goroutine has exited.
+}
+
+// NewPusher returns a Pusher that pushes the metric vectors registered with r
+// every interval, in the given order of metric types (see Registry.Push). It
+// does not push before Start is called.
+func NewPusher(r *Registry, interval time.Duration, order ...int) *Pusher {
+    return &Pusher{registry: r, interval: interval, order: order}
+}
+
+// Start starts pushing. It is a no-op if the Pusher is already running.
+func (p *Pusher) Start() {
+    p.mtx.Lock()
+    defer p.mtx.Unlock()
+    if p.stop != nil {
+        return
+    }
+    p.stop = make(chan struct{})
+    p.done = make(chan struct{})
+    go p.run(p.stop, p.done)
+}
+
+// Stop stops pushing and waits until the push in progress, if any, is done and
+// the buffered documents (see EsOpts.FlushDocs) and those queued for
+// asynchronous sending (see EsOpts.Async) have been sent. It is a no-op if the Pusher is not running. A stopped Pusher can be
+// started again.
+func (p *Pusher) Stop() {
+    p.mtx.Lock()
+    defer p.mtx.Unlock()
+    if p.stop == nil {
+        return
+    }
+    close(p.stop)
+    <-p.done
+    p.stop, p.done = nil, nil
+    for _, pusher := range p.registry.pushers(p.order) {
+        pusher.drain()
+    }
+}
+
+func (p *Pusher) run(stop, done chan struct{}) {
+    defer close(done)
+    ticker := time.NewTicker(p.interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-ticker.C:
+            p.registry.Push(p.order...)
+        }
+    }
+}
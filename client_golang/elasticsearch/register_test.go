@@ -0,0 +1,111 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "io/ioutil"
+    "runtime"
+    "testing"
+    "time"
+)
+
+func TestRegisterOrGetWith(t *testing.T) {
+    reg := NewRegistry()
+    opts := CounterOpts{Name: "test_requests_total", Help: "Total requests."}
+
+    first := NewCounter(opts)
+    got, err := RegisterOrGetWith(reg, first)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got != first {
+        t.Error("registered Collector not returned")
+    }
+
+    got, err = RegisterOrGetWith(reg, NewCounter(opts))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got != first {
+        t.Error("existing Collector not returned")
+    }
+
+    opts.Help = "Inconsistent help."
+    if _, err := RegisterOrGetWith(reg, NewCounter(opts)); err == nil {
+        t.Error("expected an error for an inconsistent Collector")
+    }
+}
+
+func TestRegisterOrGetWithStopsDiscardedVector(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    reg := NewRegistry()
+    newVec := func() *CounterVec {
+        return NewCounterVec(
+            CounterOpts{Name: "test_registered_total", Help: "Total requests."},
+            CounterEsOpts{URL: server.URL + "/metrics/doc/", Interval: 1},
+            []string{"code"},
+        )
+    }
+    existing := newVec()
+    existing.WithLabelValues("kept").Inc()
+    if _, err := RegisterOrGetWith(reg, existing); err != nil {
+        t.Fatal(err)
+    }
+    discarded := newVec()
+    discarded.WithLabelValues("discarded").Inc()
+    if got, err := RegisterOrGetWith(reg, discarded); err != nil || got != existing {
+        t.Fatalf("got %v, %v, want the existing vector", got, err)
+    }
+
+    time.Sleep(1500 * time.Millisecond)
+    existing.Stop()
+    var kept int
+    for _, doc := range server.docs(t) {
+        switch doc["code"] {
+        case "kept":
+            kept++
+        case "discarded":
+            t.Errorf("got document %v of the discarded vector", doc)
+        }
+    }
+    if kept == 0 {
+        t.Error("got no documents of the existing vector")
+    }
+}
+
+func TestRegisterOrGetWithStopsDiscardedGoroutines(t *testing.T) {
+    reg := NewRegistry()
+    newVec := func() *CounterVec {
+        return NewCounterVec(
+            CounterOpts{Name: "test_registered_total", Help: "Total requests."},
+            CounterEsOpts{NDJSONWriter: ioutil.Discard, Interval: 1, Async: true},
+            []string{"code"},
+        )
+    }
+    existing := newVec()
+    defer existing.Stop()
+    if _, err := RegisterOrGetWith(reg, existing); err != nil {
+        t.Fatal(err)
+    }
+
+    // The periodic pushes and the asynchronous sending of the discarded
+    // vector end.
+    before := runtime.NumGoroutine()
+    if got, err := RegisterOrGetWith(reg, newVec()); err != nil || got != existing {
+        t.Fatalf("got %v, %v, want the existing vector", got, err)
+    }
+    waitForGoroutines(t, before)
+}
@@ -185,6 +185,43 @@ func Unregister(c Collector) bool {
     return DefaultRegisterer.Unregister(c)
 }
 
+// RegisterOrGet registers the provided Collector with the DefaultRegisterer
+// and returns it. If an equal Collector has been registered before, the
+// existing Collector is returned instead, without an error.
+//
+// RegisterOrGet is a shortcut for RegisterOrGetWith(DefaultRegisterer, c). See
+// there for more details.
+func RegisterOrGet(c Collector) (Collector, error) {
+    return RegisterOrGetWith(DefaultRegisterer, c)
+}
+
+// RegisterOrGetWith registers the provided Collector with r and returns
+// it. If r returns an AlreadyRegisteredError, the existing Collector is
+// returned instead, without an error. This allows initialization code that
+// may run more than once to share a metric vector:
+//
+//     c, err := RegisterOrGetWith(r, NewCounterVec(opts, esOpts, labelNames))
+//     if err != nil {
+//         // Handle the error, e.g. an inconsistent help string.
+//     }
+//     requests := c.(*CounterVec)
+//
+// If c is a metric vector of this package and not the existing Collector, it
+// is stopped (see CounterVec.Stop), so that it is not pushed in addition to the
+// existing one. All other errors are returned as they are.
+func RegisterOrGetWith(r Registerer, c Collector) (Collector, error) {
+    if err := r.Register(c); err != nil {
+        if are, ok := err.(AlreadyRegisteredError); ok {
+            if p, ok := c.(esPusher); ok && c != are.ExistingCollector {
+                p.Stop()
+            }
+            return are.ExistingCollector, nil
+        }
+        return nil, err
+    }
+    return c, nil
+}
+
 // GathererFunc turns a function into a Gatherer.
 type GathererFunc func() ([]*dto.MetricFamily, error)
 
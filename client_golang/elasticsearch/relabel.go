@@ -0,0 +1,165 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// RelabelAction is the action of a RelabelConfig.
+type RelabelAction string
+
+// The supported relabel actions, named like those of Prometheus.
+const (
+    // RelabelReplace sets TargetLabel to Replacement, with the capture
+    // groups of Regex expanded, if Regex matches the concatenated values of
+    // SourceLabels. An empty result removes TargetLabel.
+    RelabelReplace RelabelAction = "replace"
+    // RelabelKeep drops the series if Regex does not match the
+    // concatenated values of SourceLabels.
+    RelabelKeep RelabelAction = "keep"
+    // RelabelDrop drops the series if Regex matches the concatenated values
+    // of SourceLabels.
+    RelabelDrop RelabelAction = "drop"
+    // RelabelLabelMap copies the value of every label whose name matches
+    // Regex to the label named by Replacement, with the capture groups of
+    // Regex expanded.
+    RelabelLabelMap RelabelAction = "labelmap"
+    // RelabelLabelDrop removes every label whose name matches Regex.
+    RelabelLabelDrop RelabelAction = "labeldrop"
+    // RelabelLabelKeep removes every label whose name does not match Regex.
+    RelabelLabelKeep RelabelAction = "labelkeep"
+)
+
+// RelabelConfig is a step of EsOpts.Relabel, modeled on relabel_config of
+// Prometheus. Regex is fully anchored, and the zero values of the fields
+// default like in Prometheus.
+type RelabelConfig struct {
+    // SourceLabels are the labels whose values, joined by Separator, are
+    // matched against Regex.
+    SourceLabels []string
+    // Separator defaults to ";".
+    Separator string
+    // Regex defaults to "(.*)".
+    Regex string
+    // TargetLabel is the label set by RelabelReplace. Capture groups of
+    // Regex are expanded.
+    TargetLabel string
+    // Replacement defaults to "$1".
+    Replacement string
+    // Action defaults to RelabelReplace.
+    Action RelabelAction
+}
+
+// compiledRelabel is a RelabelConfig with its defaults applied and its regular
+// expression compiled.
+type compiledRelabel struct {
+    RelabelConfig
+    regex *regexp.Regexp
+}
+
+// relabeler applies EsOpts.Relabel.
+type relabeler []compiledRelabel
+
+func compileRelabel(configs []RelabelConfig) (relabeler, error) {
+    r := make(relabeler, 0, len(configs))
+    for _, c := range configs {
+        if c.Separator == "" {
+            c.Separator = ";"
+        }
+        if c.Regex == "" {
+            c.Regex = "(.*)"
+        }
+        if c.Replacement == "" {
+            c.Replacement = "$1"
+        }
+        if c.Action == "" {
+            c.Action = RelabelReplace
+        }
+        switch c.Action {
+        case RelabelReplace:
+            if c.TargetLabel == "" {
+                return nil, fmt.Errorf("relabel action %q requires a target label", c.Action)
+            }
+        case RelabelKeep, RelabelDrop, RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+        default:
+            return nil, fmt.Errorf("unknown relabel action %q", c.Action)
+        }
+        regex, err := regexp.Compile("^(?:" + c.Regex + ")$")
+        if err != nil {
+            return nil, fmt.Errorf("invalid relabel regex %q: %v", c.Regex, err)
+        }
+        r = append(r, compiledRelabel{RelabelConfig: c, regex: regex})
+    }
+    return r, nil
+}
+
+// process applies all steps to labels, which it may modify, and returns the
+// resulting labels, or nil if the series is dropped.
+func (r relabeler) process(labels map[string]string) map[string]string {
+    for _, c := range r {
+        values := make([]string, 0, len(c.SourceLabels))
+        for _, label := range c.SourceLabels {
+            values = append(values, labels[label])
+        }
+        value := strings.Join(values, c.Separator)
+
+        switch c.Action {
+        case RelabelKeep:
+            if !c.regex.MatchString(value) {
+                return nil
+            }
+        case RelabelDrop:
+            if c.regex.MatchString(value) {
+                return nil
+            }
+        case RelabelReplace:
+            match := c.regex.FindStringSubmatchIndex(value)
+            if match == nil {
+                break
+            }
+            target := string(c.regex.ExpandString(nil, c.TargetLabel, value, match))
+            if result := string(c.regex.ExpandString(nil, c.Replacement, value, match)); result != "" {
+                labels[target] = result
+            } else {
+                delete(labels, target)
+            }
+        case RelabelLabelMap:
+            mapped := map[string]string{}
+            for name, v := range labels {
+                if c.regex.MatchString(name) {
+                    mapped[c.regex.ReplaceAllString(name, c.Replacement)] = v
+                }
+            }
+            for name, v := range mapped {
+                labels[name] = v
+            }
+        case RelabelLabelDrop:
+            for name := range labels {
+                if c.regex.MatchString(name) {
+                    delete(labels, name)
+                }
+            }
+        case RelabelLabelKeep:
+            for name := range labels {
+                if !c.regex.MatchString(name) {
+                    delete(labels, name)
+                }
+            }
+        }
+    }
+    return labels
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestRelabel(t *testing.T) {
+    scenarios := map[string]struct {
+        configs []RelabelConfig
+        in      map[string]string
+        want    map[string]string // nil if dropped.
+    }{
+        "replace": {
+            configs: []RelabelConfig{{
+                SourceLabels: []string{"method", "code"},
+                Regex:        "(.*);(\\d)\\d\\d",
+                TargetLabel:  "class",
+                Replacement:  "${1}_${2}xx",
+            }},
+            in:   map[string]string{"method": "get", "code": "404"},
+            want: map[string]string{"method": "get", "code": "404", "class": "get_4xx"},
+        },
+        "replace without match": {
+            configs: []RelabelConfig{{SourceLabels: []string{"code"}, Regex: "5..", TargetLabel: "error"}},
+            in:      map[string]string{"code": "404"},
+            want:    map[string]string{"code": "404"},
+        },
+        "replace with defaults": {
+            configs: []RelabelConfig{{SourceLabels: []string{"code"}, TargetLabel: "status"}},
+            in:      map[string]string{"code": "404"},
+            want:    map[string]string{"code": "404", "status": "404"},
+        },
+        "keep": {
+            configs: []RelabelConfig{{SourceLabels: []string{"code"}, Regex: "5..", Action: RelabelKeep}},
+            in:      map[string]string{"code": "404"},
+        },
+        "drop": {
+            configs: []RelabelConfig{{SourceLabels: []string{"code"}, Regex: "4..", Action: RelabelDrop}},
+            in:      map[string]string{"code": "404"},
+        },
+        "drop is anchored": {
+            configs: []RelabelConfig{{SourceLabels: []string{"code"}, Regex: "4", Action: RelabelDrop}},
+            in:      map[string]string{"code": "404"},
+            want:    map[string]string{"code": "404"},
+        },
+        "labelmap": {
+            configs: []RelabelConfig{{Regex: "k8s_(.+)", Action: RelabelLabelMap}},
+            in:      map[string]string{"k8s_pod": "p1", "code": "200"},
+            want:    map[string]string{"k8s_pod": "p1", "pod": "p1", "code": "200"},
+        },
+        "labeldrop and labelkeep": {
+            configs: []RelabelConfig{
+                {Regex: "k8s_.+", Action: RelabelLabelDrop},
+                {Regex: "code|pod", Action: RelabelLabelKeep},
+            },
+            in:   map[string]string{"k8s_pod": "p1", "pod": "p1", "code": "200", "path": "/"},
+            want: map[string]string{"pod": "p1", "code": "200"},
+        },
+    }
+    for name, s := range scenarios {
+        r, err := compileRelabel(s.configs)
+        if err != nil {
+            t.Fatalf("%s: %s", name, err)
+        }
+        if got := r.process(s.in); !reflect.DeepEqual(got, s.want) {
+            t.Errorf("%s: got %v, want %v", name, got, s.want)
+        }
+    }
+
+    for _, c := range []RelabelConfig{
+        {Action: "hashmod"},
+        {Action: RelabelReplace},
+        {Regex: "(", Action: RelabelDrop},
+    } {
+        if _, err := compileRelabel([]RelabelConfig{c}); err == nil {
+            t.Errorf("expected an error for %+v", c)
+        }
+    }
+}
+
+func TestRelabelPush(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{Relabel: []RelabelConfig{
+        {SourceLabels: []string{"rl_path"}, Regex: "/health", Action: RelabelDrop},
+        {SourceLabels: []string{"rl_path"}, TargetLabel: "route"},
+        {Regex: "rl_path", Action: RelabelLabelDrop},
+    }}, "rl_path")
+    vec.WithLabelValues("/health").Inc()
+    vec.WithLabelValues("/api").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    if _, ok := docs[0]["rl_path"]; ok || docs[0]["route"] != "/api" {
+        t.Errorf("unexpected document %v", docs[0])
+    }
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "strings"
+)
+
+// collidingLabelPrefix is prepended to the field names of labels that collide
+// with a reserved field name.
+const collidingLabelPrefix = "label_"
+
+// reservedFields are the names of the fields set by this package next to the
+// labels of a series. The fields of the quantiles are matched by prefix.
+var reservedFields = map[string]bool{
+    SUM:              true,
+    HELP:             true,
+    TYPE:             true,
+    VALUE:            true,
+    COUNT:            true,
+    FQNAME:           true,
+    TIMESTAMP:        true,
+    EPOCH_TIMESTAMP:  true,
+    QUANTILE:         true,
+    BUCKETS:          true,
+    LE:               true,
+    MIN:              true,
+    MAX:              true,
+    AVG:              true,
+    LAST:             true,
+    LOCATION:         true,
+    INSTANCE_UUID:    true,
+    RESET:            true,
+    DELETED_AT:       true,
+    CARDINALITY:      true,
+    LABELS:           true,
+    SUMMARY:          true,
+    PERCENTILE_HINTS: true,
+}
+
+// reservedField reports whether the field name is set by this package under
+// its final name, i.e. after renaming (see EsOpts.FieldNames), so that a label
+// of that name would be overwritten or overwrite it. A default name renamed
+// to another one is not reserved.
+func (m *metricMap) reservedField(name string) bool {
+    if _, renamed := m.fieldRenames[name]; !renamed &&
+        (reservedFields[name] || strings.HasPrefix(name, "QUANTILE_")) {
+        return true
+    }
+    for _, renamed := range m.fieldRenames {
+        if name == renamed {
+            return true
+        }
+    }
+    return name != "" && (name == m.esOpts.EventTimeField || name == m.esOpts.IngestTimeField)
+}
+
+// labelField returns the name of the field holding the value of label. It is
+// the label name itself unless that is a reserved field name, in which case
+// it is prefixed with collidingLabelPrefix. The labels of nested documents (see
+// EnvelopeNested) are kept in their own object, apart from the fields set by
+// this package (see addLabels), so they cannot collide and are never renamed.
+func (m *metricMap) labelField(label string) string {
+    if m.esOpts.EnvelopeVersion != EnvelopeNested && m.reservedField(label) {
+        return collidingLabelPrefix + label
+    }
+    return label
+}
+
+// warnReservedLabels logs a warning for each constant or variable label whose
+// field is renamed by labelField.
+func (m *metricMap) warnReservedLabels(log Logger) {
+    for _, label := range m.labelNames() {
+        if field := m.labelField(label); field != label {
+            log.Warnf("label %q of %s collides with a reserved field, indexing it as %q", label, m.desc.fqName, field)
+        }
+    }
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestReservedLabel(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    logger := &capturingLogger{}
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{}, "Value", "code")
+    vec.WithLabelValues("label value", "200").Add(3)
+    vec.pushDocToEs(COUNTER_TYPE, logger)
+    vec.pushDocToEs(COUNTER_TYPE, logger)
+
+    docs := server.docs(t)
+    if len(docs) != 2 {
+        t.Fatalf("got %d documents, want 2", len(docs))
+    }
+    doc := docs[0]
+    if doc[VALUE] != 3.0 || doc[collidingLabelPrefix+VALUE] != "label value" || doc["code"] != "200" {
+        t.Errorf("unexpected document %v", doc)
+    }
+
+    logger.mtx.Lock()
+    defer logger.mtx.Unlock()
+    if len(logger.messages) != 1 || !strings.Contains(logger.messages[0], `"Value"`) {
+        t.Errorf("got messages %q, want a single warning about the label Value", logger.messages)
+    }
+
+    vec = newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{EnvelopeVersion: EnvelopeNested}, "Value")
+    if got := vec.labelField(VALUE); got != VALUE {
+        t.Errorf("got field %q for a nested label, want %q", got, VALUE)
+    }
+    nestedLogger := &capturingLogger{}
+    vec.WithLabelValues("label value").Add(3)
+    vec.pushDocToEs(COUNTER_TYPE, nestedLogger)
+    doc = server.docs(t)[2]
+    labels, _ := doc[LABELS].(map[string]interface{})
+    if doc[VALUE] != 3.0 || labels[VALUE] != "label value" {
+        t.Errorf("unexpected nested document %v", doc)
+    }
+    if len(nestedLogger.messages) != 0 {
+        t.Errorf("got messages %q for a nested label, want none", nestedLogger.messages)
+    }
+}
+
+func TestConstLabels(t *testing.T) {
+    server := newTestServer()
+    defer server.Close()
+
+    desc := NewDesc("test_const", "helpless", []string{"code"}, Labels{"service": "api", "env": "prod", "Type": "internal"})
+    vec := &CounterVec{newMetricVec(desc, server.URL+"/metrics/doc/", EsOpts{}, func(lvs ...string) Metric {
+        result := &counter{desc: desc, labelPairs: makeLabelPairs(desc, lvs)}
+        result.init(result)
+        return result
+    })}
+    logger := &capturingLogger{}
+    vec.WithLabelValues("200").Inc()
+    vec.pushDocToEs(COUNTER_TYPE, logger)
+
+    docs := server.docs(t)
+    if len(docs) != 1 {
+        t.Fatalf("got %d documents, want 1", len(docs))
+    }
+    doc := docs[0]
+    if doc["service"] != "api" || doc["env"] != "prod" || doc["code"] != "200" {
+        t.Errorf("labels missing in document %v", doc)
+    }
+    if doc[TYPE] != METRIC_COUNTER || doc[collidingLabelPrefix+TYPE] != "internal" {
+        t.Errorf("constant label Type not renamed in document %v", doc)
+    }
+    if len(logger.messages) != 1 {
+        t.Errorf("got messages %q, want a warning about the label Type", logger.messages)
+    }
+}
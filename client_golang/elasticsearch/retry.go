@@ -0,0 +1,132 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "fmt"
+    "math/rand"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// maxErrorBodyLength is the maximum number of bytes of a response body
+// included in a statusError.
+const maxErrorBodyLength = 512
+
+// statusError is returned for responses with a non-2xx status code.
+type statusError struct {
+    method string
+    url    string
+    code   int
+    status string
+    body   string // Truncated to maxErrorBodyLength bytes.
+
+    // retryAfter is the delay requested by the Retry-After header, 0 if
+    // absent.
+    retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+    if e.body == "" {
+        return fmt.Sprintf("%s %s returned status %s", e.method, e.url, e.status)
+    }
+    return fmt.Sprintf("%s %s returned status %s: %s", e.method, e.url, e.status, e.body)
+}
+
+// goRequest PUTs a single document to url, retrying as configured by
+// EsOpts.MaxRetries, EsOpts.RetryBackoff and EsOpts.RetryableStatusCodes. The
+// error of the last attempt is returned.
+func (m *metricMap) goRequest(ctx context.Context, url, data string) error {
+    backoff := m.esOpts.RetryBackoff
+    for retries := 0; ; retries++ {
+        err := m.putDoc(ctx, url, data)
+        if err == nil || retries >= m.esOpts.MaxRetries || !m.retryable(ctx, err) {
+            return err
+        }
+        select {
+        case <-time.After(m.retryDelay(err, backoff)):
+        case <-ctx.Done():
+            return err
+        }
+        backoff *= 2
+    }
+}
+
+// retryDelay returns the delay before retrying a request that failed with err.
+// That is the delay requested by the server with a Retry-After header, if any,
+// or else backoff randomized as configured by EsOpts.RetryJitter.
+func (m *metricMap) retryDelay(err error, backoff time.Duration) time.Duration {
+    if e, ok := err.(*statusError); ok && e.retryAfter > 0 {
+        return e.retryAfter
+    }
+    return m.jitter(backoff)
+}
+
+// parseRetryAfter returns the delay requested by the value of a Retry-After
+// header, either in seconds or as an HTTP date, relative to now. It returns 0
+// for an absent or invalid value.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+    if value == "" {
+        return 0
+    }
+    if seconds, err := strconv.Atoi(value); err == nil {
+        if seconds < 0 {
+            return 0
+        }
+        return time.Duration(seconds) * time.Second
+    }
+    if t, err := http.ParseTime(value); err == nil && t.After(now) {
+        return t.Sub(now)
+    }
+    return 0
+}
+
+// jitter returns backoff randomized as configured by EsOpts.RetryJitter.
+func (m *metricMap) jitter(backoff time.Duration) time.Duration {
+    if m.esOpts.RetryJitter <= 0 {
+        return backoff
+    }
+    return time.Duration(float64(backoff) * (1 + m.esOpts.RetryJitter*(2*rand.Float64()-1)))
+}
+
+// retryable returns whether a request that failed with err is to be retried.
+func (m *metricMap) retryable(ctx context.Context, err error) bool {
+    if ctx.Err() != nil {
+        return false
+    }
+    switch e := err.(type) {
+    case *statusError:
+        return m.retryableStatus(e.code)
+    case *bulkError:
+        return len(e.retry) > 0
+    }
+    // Network errors are always retried.
+    return true
+}
+
+// retryableStatus returns whether a request that failed with the HTTP status
+// code is to be retried.
+func (m *metricMap) retryableStatus(code int) bool {
+    if m.esOpts.RetryableStatusCodes == nil {
+        return code == http.StatusTooManyRequests || code/100 == 5
+    }
+    for _, c := range m.esOpts.RetryableStatusCodes {
+        if code == c {
+            return true
+        }
+    }
+    return false
+}
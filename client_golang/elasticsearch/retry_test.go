@@ -0,0 +1,227 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "net/http"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestRetryableStatusCodes(t *testing.T) {
+    scenarios := map[string]struct {
+        retryable    []int
+        codes        []int // Status codes answered, 200 once exhausted.
+        wantRequests int
+        wantCode     int // 0 means success.
+    }{
+        "default retries 5xx": {
+            codes:        []int{503, 500},
+            wantRequests: 3,
+        },
+        "default retries 429": {
+            codes:        []int{429},
+            wantRequests: 2,
+        },
+        "default does not retry 4xx": {
+            codes:        []int{408},
+            wantRequests: 1,
+            wantCode:     408,
+        },
+        "custom set": {
+            retryable:    []int{408, 499},
+            codes:        []int{408, 499},
+            wantRequests: 3,
+        },
+        "custom set overrides default": {
+            retryable:    []int{408},
+            codes:        []int{503},
+            wantRequests: 1,
+            wantCode:     503,
+        },
+        "retries exhausted": {
+            codes:        []int{503, 503, 503, 503},
+            wantRequests: 4,
+            wantCode:     503,
+        },
+    }
+
+    for name, s := range scenarios {
+        t.Run(name, func(t *testing.T) {
+            var (
+                mtx      sync.Mutex
+                requests int
+            )
+            server := startServer(func(w http.ResponseWriter, r *http.Request) {
+                mtx.Lock()
+                defer mtx.Unlock()
+                if requests < len(s.codes) {
+                    w.WriteHeader(s.codes[requests])
+                }
+                requests++
+            })
+            defer server.Close()
+
+            vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+                MaxRetries:           3,
+                RetryableStatusCodes: s.retryable,
+            })
+            err := vec.goRequest(context.Background(), server.URL+"/metrics/doc/1", "{}")
+
+            if requests != s.wantRequests {
+                t.Errorf("got %d requests, want %d", requests, s.wantRequests)
+            }
+            if s.wantCode == 0 {
+                if err != nil {
+                    t.Errorf("unexpected error: %s", err)
+                }
+                return
+            }
+            if se, ok := err.(*statusError); !ok || se.code != s.wantCode {
+                t.Errorf("got error %v, want status %d", err, s.wantCode)
+            }
+        })
+    }
+}
+
+func TestStatusErrorBody(t *testing.T) {
+    scenarios := []struct {
+        code int
+        body string
+        want string
+    }{
+        {
+            code: 400,
+            body: `{"error":{"type":"mapper_parsing_exception"},"status":400}`,
+            want: `returned status 400 Bad Request: {"error":{"type":"mapper_parsing_exception"},"status":400}`,
+        },
+        {
+            code: 401,
+            body: `{"error":"` + strings.Repeat("x", 1000) + `"}`,
+            want: `returned status 401 Unauthorized: {"error":"` + strings.Repeat("x", 502) + "... (500 more bytes)",
+        },
+    }
+    for _, s := range scenarios {
+        server := startServer(func(w http.ResponseWriter, r *http.Request) {
+            w.WriteHeader(s.code)
+            w.Write([]byte(s.body))
+        })
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{})
+        err := vec.goRequest(context.Background(), server.URL+"/metrics/doc/1", "{}")
+        server.Close()
+
+        se, ok := err.(*statusError)
+        if !ok {
+            t.Errorf("%d: got error %v, want a statusError", s.code, err)
+            continue
+        }
+        if se.code != s.code || !strings.HasSuffix(se.Error(), s.want) {
+            t.Errorf("%d: got error %q, want suffix %q", s.code, se.Error(), s.want)
+        }
+    }
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+    var (
+        mtx      sync.Mutex
+        requests int
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        defer mtx.Unlock()
+        if requests++; requests <= 2 {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        MaxRetries:   3,
+        RetryBackoff: time.Millisecond,
+        RetryJitter:  0.5,
+    })
+    if err := vec.goRequest(context.Background(), server.URL+"/metrics/doc/1", "{}"); err != nil {
+        t.Errorf("unexpected error: %v", err)
+    }
+    mtx.Lock()
+    defer mtx.Unlock()
+    if requests != 3 {
+        t.Errorf("got %d requests, want 3", requests)
+    }
+}
+
+func TestJitter(t *testing.T) {
+    vec := newTestCounterVec("", EsOpts{RetryJitter: 0.2})
+    for i := 0; i < 100; i++ {
+        if d := vec.jitter(time.Second); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+            t.Fatalf("got delay %v, want within 20%% of 1s", d)
+        }
+    }
+    if d := newTestCounterVec("", EsOpts{}).jitter(time.Second); d != time.Second {
+        t.Errorf("got delay %v without jitter, want 1s", d)
+    }
+}
+
+func TestRetryAfter(t *testing.T) {
+    var (
+        mtx      sync.Mutex
+        requests int
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        defer mtx.Unlock()
+        if requests++; requests == 1 {
+            w.Header().Set("Retry-After", "1")
+            w.WriteHeader(http.StatusTooManyRequests)
+        }
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+        MaxRetries:   1,
+        RetryBackoff: time.Millisecond,
+    })
+    start := time.Now()
+    if err := vec.goRequest(context.Background(), server.URL+"/metrics/doc/1", "{}"); err != nil {
+        t.Errorf("unexpected error: %v", err)
+    }
+    if elapsed := time.Since(start); elapsed < time.Second {
+        t.Errorf("retried after %v, want at least the 1s of Retry-After", elapsed)
+    }
+    mtx.Lock()
+    defer mtx.Unlock()
+    if requests != 2 {
+        t.Errorf("got %d requests, want 2", requests)
+    }
+}
+
+func TestParseRetryAfter(t *testing.T) {
+    now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+    scenarios := map[string]time.Duration{
+        "":                              0,
+        "3":                             3 * time.Second,
+        "-1":                            0,
+        "soon":                          0,
+        "Sat, 01 Jun 2019 12:00:30 GMT": 30 * time.Second,
+        "Sat, 01 Jun 2019 11:59:00 GMT": 0,
+    }
+    for value, want := range scenarios {
+        if got := parseRetryAfter(value, now); got != want {
+            t.Errorf("%q: got %v, want %v", value, got, want)
+        }
+    }
+}
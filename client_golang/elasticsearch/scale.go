@@ -0,0 +1,59 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "github.com/golang/protobuf/proto"
+
+    dto "github.com/Schneizelw/elasticsearch/client_model/go"
+)
+
+// scaleMetric multiplies all values of dtoMetric by scale, i.e. the value of a
+// counter, gauge or untyped metric, the sum and quantile values of a summary, and the sum and
+// bucket upper bounds of a histogram. Counts are not scaled.
+func scaleMetric(dtoMetric *dto.Metric, scale float64) {
+    scaled := func(v float64) *float64 {
+        return proto.Float64(v * scale)
+    }
+    if c := dtoMetric.Counter; c != nil {
+        c.Value = scaled(c.GetValue())
+    }
+    if g := dtoMetric.Gauge; g != nil {
+        g.Value = scaled(g.GetValue())
+    }
+    if u := dtoMetric.Untyped; u != nil {
+        u.Value = scaled(u.GetValue())
+    }
+    if s := dtoMetric.Summary; s != nil {
+        s.SampleSum = scaled(s.GetSampleSum())
+        for _, q := range s.Quantile {
+            q.Value = scaled(q.GetValue())
+        }
+    }
+    if h := dtoMetric.Histogram; h != nil {
+        h.SampleSum = scaled(h.GetSampleSum())
+        for _, b := range h.Bucket {
+            b.UpperBound = scaled(b.GetUpperBound())
+        }
+    }
+}
+
+// scaleAggregates multiplies the gauge aggregates in docMap by scale.
+func scaleAggregates(docMap map[string]interface{}, scale float64) {
+    for _, field := range []string{MIN, MAX, AVG, LAST} {
+        if v, ok := docMap[field].(float64); ok {
+            docMap[field] = v * scale
+        }
+    }
+}
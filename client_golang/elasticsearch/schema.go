@@ -0,0 +1,183 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// fieldMapping is the mapping of a single field.
+type fieldMapping struct {
+    Type       string                  `json:"type"`
+    Properties map[string]fieldMapping `json:"properties"` // Of objects.
+}
+
+// flattenMapping adds the fields of properties, with the fields of objects
+// named by their dotted path, to flat.
+func flattenMapping(flat map[string]fieldMapping, prefix string, properties map[string]fieldMapping) {
+    for name, field := range properties {
+        flat[prefix+name] = field
+        if field.Properties != nil {
+            flattenMapping(flat, prefix+name+".", field.Properties)
+        }
+    }
+}
+
+// expectedMapping returns the types the fields of the documents of metricType
+// are expected to be mapped to. See EsOpts.SchemaStrict.
+func (m *metricMap) expectedMapping(metricType int) map[string]string {
+    expected := map[string]string{}
+    if !m.esOpts.EpochTimestamp || m.esOpts.LegacyTimestamp {
+        expected[TIMESTAMP] = "date"
+    }
+    if m.esOpts.EpochTimestamp {
+        expected[EPOCH_TIMESTAMP] = "date"
+    }
+    if metricType == COUNTER_TYPE || metricType == GAUGE_TYPE || metricType == UNTYPED_TYPE {
+        expected[VALUE] = "double"
+    }
+    if metricType == COUNTER_TYPE && m.esOpts.ExactIntegers {
+        expected[VALUE] = "long"
+    }
+    expected = m.documentFields(expected)
+    // The fields of the labels are not renamed by EsOpts.FieldNames.
+    for _, label := range m.labelNames() {
+        if geo := m.esOpts.GeoPoint; geo != nil && geo.DropLabels &&
+            (label == geo.LatLabel || label == geo.LonLabel) {
+            continue
+        }
+        field := m.labelField(label)
+        if m.esOpts.EnvelopeVersion == EnvelopeNested {
+            field = LABELS + "." + field
+        }
+        expected[m.esOpts.FieldPrefix+field] = "keyword"
+    }
+    return expected
+}
+
+// documentFields returns a copy of fields, which maps field names to types,
+// with the field names as they appear in the pushed documents, i.e. renamed as
+// configured by EsOpts.FieldNames and prefixed with EsOpts.FieldPrefix.
+func (m *metricMap) documentFields(fields map[string]string) map[string]string {
+    named := make(map[string]string, len(fields))
+    for field, typ := range fields {
+        if name, ok := m.fieldRenames[field]; ok {
+            field = name
+        }
+        if field != EPOCH_TIMESTAMP {
+            field = m.esOpts.FieldPrefix + field
+        }
+        named[field] = typ
+    }
+    return named
+}
+
+// indexMapping returns the mapped fields of the index addressed by the index
+// URL u. The fields of objects are named by their dotted path.
+func (m *metricMap) indexMapping(ctx context.Context, u string) (map[string]fieldMapping, error) {
+    index, _, err := indexAndType(u)
+    if err != nil {
+        return nil, err
+    }
+    root, err := rootURL(u)
+    if err != nil {
+        return nil, err
+    }
+    body, err := m.request(ctx, "GET", root+index+"/_mapping", "application/json", nil)
+    if err != nil {
+        return nil, err
+    }
+    // The mappings are keyed by mapping type before Elasticsearch 7.
+    var res map[string]struct {
+        Mappings map[string]json.RawMessage `json:"mappings"`
+    }
+    if err := json.Unmarshal(body, &res); err != nil {
+        return nil, fmt.Errorf("decoding mapping of index %s: %v", index, err)
+    }
+    var properties json.RawMessage
+    for _, idx := range res {
+        if p, ok := idx.Mappings["properties"]; ok {
+            properties = p
+            break
+        }
+        for _, mapping := range idx.Mappings {
+            var typed struct {
+                Properties json.RawMessage `json:"properties"`
+            }
+            if json.Unmarshal(mapping, &typed) == nil && typed.Properties != nil {
+                properties = typed.Properties
+                break
+            }
+        }
+    }
+    fields := map[string]fieldMapping{}
+    if properties == nil {
+        return fields, nil
+    }
+    var top map[string]fieldMapping
+    if err := json.Unmarshal(properties, &top); err != nil {
+        return nil, fmt.Errorf("decoding mapping of index %s: %v", index, err)
+    }
+    flattenMapping(fields, "", top)
+    return fields, nil
+}
+
+// verifySchema checks that the indices addressed by urls map the fields of the
+// documents of metricType as expected. Once the check of an index has
+// succeeded, it is not repeated. If it fails, nothing must be pushed, and the
+// check is repeated on the next push. See EsOpts.SchemaStrict. Cancelling ctx
+// aborts the check.
+func (m *metricMap) verifySchema(ctx context.Context, metricType int, urls []string) error {
+    if !m.esOpts.SchemaStrict || m.offline() {
+        return nil
+    }
+    m.pushMtx.Lock()
+    defer m.pushMtx.Unlock()
+
+    expected := m.expectedMapping(metricType)
+    for _, u := range urls {
+        if m.schemaVerified[u] {
+            continue
+        }
+        fields, err := m.indexMapping(ctx, u)
+        if err != nil {
+            return fmt.Errorf("not pushing %s, cannot get mapping of %s: %v", m.desc.fqName, u, err)
+        }
+        var mismatches []string
+        for field, typ := range expected {
+            if got := fields[field].Type; got != typ {
+                if got == "" {
+                    got = "unmapped"
+                }
+                mismatches = append(mismatches, fmt.Sprintf("%s is %s, not %s", field, got, typ))
+            }
+        }
+        if len(mismatches) > 0 {
+            sort.Strings(mismatches)
+            return fmt.Errorf(
+                "not pushing %s, mapping of %s does not match: %s",
+                m.desc.fqName, u, strings.Join(mismatches, ", "),
+            )
+        }
+        if m.schemaVerified == nil {
+            m.schemaVerified = map[string]bool{}
+        }
+        m.schemaVerified[u] = true
+    }
+    return nil
+}
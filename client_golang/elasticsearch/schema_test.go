@@ -0,0 +1,80 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "net/http"
+    "sync"
+    "testing"
+)
+
+func TestSchemaStrict(t *testing.T) {
+    var (
+        mtx      sync.Mutex
+        mapping  string
+        mappings int // Number of mapping requests.
+        docs     int
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        mtx.Lock()
+        defer mtx.Unlock()
+        if r.Method == "GET" && r.URL.Path == "/metrics/_mapping" {
+            mappings++
+            w.Write([]byte(mapping))
+            return
+        }
+        docs++
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{SchemaStrict: true}, "schema_code")
+    vec.WithLabelValues("200").Inc()
+
+    // Elasticsearch 6 format with a mismatching Value.
+    mapping = `{"metrics":{"mappings":{"doc":{"properties":{
+        "Value":{"type":"float"},"Timestamp":{"type":"date"},"schema_code":{"type":"keyword"}}}}}}`
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if docs != 0 {
+        t.Fatalf("got %d documents despite mismatching mapping", docs)
+    }
+    if err := vec.verifySchema(context.Background(), COUNTER_TYPE, []string{vec.url}); err == nil {
+        t.Error("expected an error for a mismatching mapping")
+    }
+
+    // Elasticsearch 7 format.
+    mapping = `{"metrics":{"mappings":{"properties":{
+        "Value":{"type":"double"},"Timestamp":{"type":"date"},"schema_code":{"type":"keyword"},"Help":{"type":"text"}}}}}`
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+    if docs != 2 {
+        t.Errorf("got %d documents, want 2", docs)
+    }
+    if mappings != 3 {
+        t.Errorf("got %d mapping requests, want 3", mappings)
+    }
+}
+
+func TestFlattenMapping(t *testing.T) {
+    flat := map[string]fieldMapping{}
+    flattenMapping(flat, "", map[string]fieldMapping{
+        "Value": {Type: "double"},
+        "Labels": {Properties: map[string]fieldMapping{
+            "code": {Type: "keyword"},
+        }},
+    })
+    if flat["Value"].Type != "double" || flat["Labels.code"].Type != "keyword" {
+        t.Errorf("unexpected flattened mapping %v", flat)
+    }
+}
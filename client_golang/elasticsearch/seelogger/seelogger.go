@@ -0,0 +1,56 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package seelogger provides the seelog logger that the elasticsearch package
+// used by default to write the warnings and errors of the pushes to hourly
+// rolling log files. Set it as EsOpts.Logger to keep these log files:
+//
+//     esOpts.Logger = seelogger.New(fqName + elasticsearch.WARN)
+//
+// It lives in a package of its own, so that only programs using it depend on
+// seelog.
+package seelogger
+
+import (
+    "fmt"
+
+    "github.com/cihub/seelog"
+
+    "github.com/Schneizelw/elasticsearch/client_golang/elasticsearch"
+)
+
+var _ elasticsearch.Logger = seelog.LoggerInterface(nil)
+
+// New returns a seelog logger writing warnings to the log file
+// ./.metricWarnLog/metricLog_<logFileName>.log, rolled hourly. It panics if
+// the logger cannot be created.
+func New(logFileName string) seelog.LoggerInterface {
+    logConfigStr := `
+        <seelog levels="info,warn">
+            <outputs formatid="runtime">
+                <buffered size="10000" flushperiod="1000">
+                    <rollingfile type="date" filename="./.metricWarnLog/metricLog_%v.log" datepattern="2006-01-02-15" maxrolls="120"/> 
+                </buffered>
+            </outputs>
+            <formats>
+                <format id="runtime" format="%%Date %%Time [%%LEVEL] %%Msg%%n"/>
+            </formats>
+        </seelog>
+    `
+    logConfigStr = fmt.Sprintf(logConfigStr, logFileName)
+    logger, err := seelog.LoggerFromConfigAsString(logConfigStr)
+    if err != nil {
+        panic(err)
+    }
+    return logger
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "fmt"
+)
+
+// maxDroppedSeries is the number of dropped series whose detached metric is
+// kept, see limitedSeries.
+const maxDroppedSeries = 1024
+
+// checkSeriesLimit returns an error if creating a series with the label values
+// lvs would exceed EsOpts.SeriesLimitPerLabel for any label, naming the first
+// such label, or EsOpts.MaxSeries.
+//
+// It must be called while holding the mutex.
+func (m *metricMap) checkSeriesLimit(lvs []string) error {
+    if limit := m.esOpts.SeriesLimitPerLabel; limit > 0 && m.labelValues != nil {
+        for i, value := range lvs {
+            counts := m.labelValues[i]
+            if _, ok := counts[value]; !ok && len(counts) >= limit {
+                return fmt.Errorf(
+                    "refusing to create a series of %s with label values %q, label %q already has %d distinct values",
+                    m.desc.fqName, lvs, m.desc.variableLabels[i], len(counts),
+                )
+            }
+        }
+    }
+    if m.esOpts.MaxSeries > 0 && m.series >= m.esOpts.MaxSeries {
+        return fmt.Errorf(
+            "refusing to create a series of %s with label values %q, it already has %d series",
+            m.desc.fqName, lvs, m.series,
+        )
+    }
+    return nil
+}
+
+// limitedSeries handles the series with the label values lvs and the hash h
+// that could not be created because of err, a series limit, as configured by
+// EsOpts.MaxSeriesPolicy. Each such series is counted once as dropped series.
+// With DropSeries, the same detached metric is returned for repeated
+// creations, as long as no more than maxDroppedSeries series were dropped
+// since and no series was deleted. Otherwise, err is returned.
+//
+// It must be called while holding the mutex.
+func (m *metricMap) limitedSeries(h uint64, lvs []string, err error) (Metric, error) {
+    if metric, ok := m.dropped[h]; ok {
+        if m.esOpts.MaxSeriesPolicy == DropSeries {
+            return metric, nil
+        }
+        return nil, err
+    }
+    if m.dropped == nil || len(m.dropped) >= maxDroppedSeries {
+        m.dropped = map[uint64]Metric{}
+    }
+    m.pushMetrics.droppedSeries.Inc()
+    if m.esOpts.MaxSeriesPolicy != DropSeries {
+        m.dropped[h] = nil
+        return nil, err
+    }
+    metric := m.newMetric(lvs...)
+    m.dropped[h] = metric
+    return metric, nil
+}
+
+// trackLabelValues adds delta to the number of series with the label values
+// lvs and to the total number of series. Deleting a series makes room for the
+// dropped ones, which are therefore forgotten.
+//
+// It must be called while holding the mutex.
+func (m *metricMap) trackLabelValues(lvs []string, delta int) {
+    m.series += delta
+    if delta < 0 {
+        m.dropped = nil
+    }
+    if m.esOpts.SeriesLimitPerLabel <= 0 {
+        return
+    }
+    if m.labelValues == nil {
+        m.labelValues = make([]map[string]int, len(lvs))
+        for i := range m.labelValues {
+            m.labelValues[i] = map[string]int{}
+        }
+    }
+    for i, value := range lvs {
+        if m.labelValues[i][value] += delta; m.labelValues[i][value] <= 0 {
+            delete(m.labelValues[i], value)
+        }
+    }
+}
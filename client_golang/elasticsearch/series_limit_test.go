@@ -0,0 +1,112 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "strings"
+    "testing"
+
+    dto "github.com/Schneizelw/elasticsearch/client_model/go"
+)
+
+func TestSeriesLimitPerLabel(t *testing.T) {
+    vec := newTestCounterVec("", EsOpts{SeriesLimitPerLabel: 2}, "code", "request_id")
+
+    if _, err := vec.GetMetricWithLabelValues("200", "1"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := vec.GetMetricWith(Labels{"code": "500", "request_id": "2"}); err != nil {
+        t.Fatal(err)
+    }
+    // Existing values remain usable.
+    if _, err := vec.GetMetricWithLabelValues("500", "1"); err != nil {
+        t.Fatal(err)
+    }
+
+    _, err := vec.GetMetricWithLabelValues("200", "3")
+    if err == nil || !strings.Contains(err.Error(), `"request_id"`) {
+        t.Fatalf("got error %v, want one naming request_id", err)
+    }
+    if _, err := vec.GetMetricWith(Labels{"code": "200", "request_id": "3"}); err == nil {
+        t.Fatal("expected an error")
+    }
+
+    // Deleting the only series with request_id 2 frees its value.
+    if !vec.DeleteLabelValues("500", "2") {
+        t.Fatal("series not deleted")
+    }
+    if _, err := vec.GetMetricWithLabelValues("200", "3"); err != nil {
+        t.Fatal(err)
+    }
+
+    vec.Reset()
+    for _, id := range []string{"4", "5"} {
+        if _, err := vec.GetMetricWithLabelValues("200", id); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestMaxSeries(t *testing.T) {
+    for _, policy := range []MaxSeriesPolicy{RejectSeries, DropSeries} {
+        vec := newTestCounterVec("", EsOpts{MaxSeries: 3, MaxSeriesPolicy: policy}, "request_id")
+        for _, id := range []string{"1", "2", "3"} {
+            if _, err := vec.GetMetricWithLabelValues(id); err != nil {
+                t.Fatalf("policy %d: %v", policy, err)
+            }
+        }
+        // Existing series remain usable.
+        if _, err := vec.GetMetricWith(Labels{"request_id": "1"}); err != nil {
+            t.Fatalf("policy %d: %v", policy, err)
+        }
+
+        c, err := vec.GetMetricWithLabelValues("4")
+        switch policy {
+        case RejectSeries:
+            if err == nil || !strings.Contains(err.Error(), "3 series") {
+                t.Errorf("got error %v for the 4th series, want one stating the limit", err)
+            }
+        case DropSeries:
+            if err != nil || c == nil {
+                t.Fatalf("got %v, %v for the 4th series, want a detached counter", c, err)
+            }
+            c.Inc()
+        }
+        if _, err := vec.GetMetricWith(Labels{"request_id": "5"}); (err == nil) != (policy == DropSeries) {
+            t.Errorf("policy %d: got error %v for the 5th series", policy, err)
+        }
+        // Creating a dropped series again neither allocates a further
+        // metric nor counts it again.
+        for i := 0; i < 3; i++ {
+            again, _ := vec.GetMetricWithLabelValues("4")
+            if policy == DropSeries && again != c {
+                t.Errorf("policy %d: got another metric for the dropped 4th series", policy)
+            }
+        }
+        if got := collectedMetrics(vec); got != 3 {
+            t.Errorf("policy %d: got %d series, want 3", policy, got)
+        }
+        m := &dto.Metric{}
+        vec.pushMetrics.droppedSeries.Write(m)
+        if got := m.GetCounter().GetValue(); got != 2 {
+            t.Errorf("policy %d: got %v dropped series, want 2", policy, got)
+        }
+
+        // Deleting a series makes room for another one.
+        vec.DeleteLabelValues("2")
+        if _, err := vec.GetMetricWithLabelValues("4"); err != nil {
+            t.Errorf("policy %d: %v", policy, err)
+        }
+    }
+}
@@ -0,0 +1,41 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "errors"
+    "strings"
+)
+
+// apiKeyPrefix starts the Authorization header of API key credentials.
+const apiKeyPrefix = "ApiKey "
+
+// validateServerless returns an error if esOpts enables serverless mode along
+// with options not supported in it. See EsOpts.Serverless.
+func validateServerless(esOpts EsOpts) error {
+    if !esOpts.Serverless {
+        return nil
+    }
+    switch {
+    case esOpts.Credentials == "" && esOpts.CredentialsProvider == nil:
+        return errors.New("serverless mode requires API key credentials")
+    case esOpts.Credentials != "" && !strings.HasPrefix(esOpts.Credentials, apiKeyPrefix):
+        return errors.New("serverless mode requires API key credentials, starting with " + strings.TrimSpace(apiKeyPrefix))
+    case esOpts.IdLabel != "":
+        return errors.New("serverless mode does not support IdLabel, data streams do not allow overwriting documents")
+    case !esOpts.EpochTimestamp:
+        return errors.New("serverless mode requires EpochTimestamp, data streams reject documents without @timestamp")
+    }
+    return nil
+}
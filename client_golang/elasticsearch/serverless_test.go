@@ -0,0 +1,110 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "strings"
+    "sync"
+    "testing"
+)
+
+func TestValidateServerless(t *testing.T) {
+    provider := func() (string, error) { return "ApiKey abc", nil }
+    scenarios := []struct {
+        esOpts EsOpts
+        valid  bool
+    }{
+        {esOpts: EsOpts{}, valid: true},
+        {esOpts: EsOpts{Serverless: true, Credentials: "ApiKey abc", EpochTimestamp: true}, valid: true},
+        {esOpts: EsOpts{Serverless: true, CredentialsProvider: provider, EpochTimestamp: true}, valid: true},
+        {esOpts: EsOpts{Serverless: true, EpochTimestamp: true}},
+        {esOpts: EsOpts{Serverless: true, Credentials: "Basic dXNlcjpwYXNz", EpochTimestamp: true}},
+        {esOpts: EsOpts{Serverless: true, Credentials: "ApiKey abc", EpochTimestamp: true, IdLabel: "host"}},
+        {esOpts: EsOpts{Serverless: true, Credentials: "ApiKey abc"}},
+    }
+    for i, s := range scenarios {
+        if err := validateServerless(s.esOpts); (err == nil) != s.valid {
+            t.Errorf("%d. got error %v, want valid %t", i, err, s.valid)
+        }
+    }
+}
+
+func TestServerless(t *testing.T) {
+    var (
+        mtx   sync.Mutex
+        paths []string
+        auth  string
+        body  string
+    )
+    server := startServer(func(w http.ResponseWriter, r *http.Request) {
+        b, _ := ioutil.ReadAll(r.Body)
+        mtx.Lock()
+        paths = append(paths, r.Method+" "+r.URL.Path)
+        auth = r.Header.Get("Authorization")
+        body = string(b)
+        mtx.Unlock()
+        // Like a data stream, reject the documents without @timestamp.
+        var (
+            items  []map[string]interface{}
+            failed bool
+        )
+        lines := bulkLines(b)
+        for i := 1; i < len(lines); i += 2 {
+            var doc map[string]interface{}
+            json.Unmarshal(lines[i], &doc)
+            if _, ok := doc["@timestamp"]; !ok {
+                failed = true
+                items = append(items, map[string]interface{}{"create": map[string]interface{}{
+                    "status": http.StatusBadRequest,
+                    "error":  map[string]string{"type": "illegal_argument_exception", "reason": "data stream timestamp field [@timestamp] is missing"},
+                }})
+                continue
+            }
+            items = append(items, map[string]interface{}{"create": map[string]interface{}{"status": http.StatusCreated}})
+        }
+        json.NewEncoder(w).Encode(map[string]interface{}{"errors": failed, "items": items})
+    })
+    defer server.Close()
+
+    vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{Serverless: true, Credentials: "ApiKey abc", EpochTimestamp: true})
+    vec.log = DiscardLogger
+    vec.WithLabelValues().Inc()
+    if err := vec.PushContext(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+
+    mtx.Lock()
+    defer mtx.Unlock()
+    if len(paths) != 1 || paths[0] != "POST /_bulk" {
+        t.Fatalf("got requests %q, want one bulk request", paths)
+    }
+    if auth != "ApiKey abc" {
+        t.Errorf("got Authorization header %q", auth)
+    }
+    var action map[string]map[string]interface{}
+    if err := json.Unmarshal([]byte(strings.Split(body, "\n")[0]), &action); err != nil {
+        t.Fatal(err)
+    }
+    meta, ok := action["create"]
+    if !ok {
+        t.Fatalf("got action %v, want create", action)
+    }
+    if _, ok := meta["_type"]; ok || meta["_index"] != "metrics" {
+        t.Errorf("unexpected action metadata %v", meta)
+    }
+}
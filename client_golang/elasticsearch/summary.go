@@ -14,6 +14,7 @@
 package elasticsearch
 
 import (
+    "context"
     "fmt"
     "math"
     "runtime"
@@ -530,7 +531,7 @@ func NewSummaryVec(opts SummaryOpts, esOpts SummaryEsOpts, labelNames []string)
             panic(errQuantileLabelNotAllowed)
         }
     }
-    url := BuildEsUrl(esOpts.Host, esOpts.Port, esOpts.EsIndex, esOpts.EsType)
+    url := indexURL(EsOpts(esOpts))
     desc := NewDesc(
         BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
         opts.Help,
@@ -538,25 +539,56 @@ func NewSummaryVec(opts SummaryOpts, esOpts SummaryEsOpts, labelNames []string)
         opts.ConstLabels,
     )
     sv := SummaryVec{
-        metricVec: newMetricVec(desc, url, func(lvs ...string) Metric {
+        metricVec: newMetricVec(desc, url, EsOpts(esOpts), func(lvs ...string) Metric {
             return newSummary(desc, opts, lvs...)
         }),
     }
-    go sv.monitor(esOpts.Interval, desc.fqName)
+    if esOpts.Interval > 0 {
+        go sv.monitor(esOpts.Interval, desc.fqName)
+    }
     return &sv
 }
 
 func (v *SummaryVec) monitor(second int, fqName string) {
     summaryType := 3
     ticker := time.NewTicker(time.Duration(second)*time.Second)
-    summaryLog := SetLog(fqName + WARN)
+    summaryLog := newLogger(fqName, v.metricVec.metricMap.esOpts)
+    defer ticker.Stop()
     for {
-        <-ticker.C
+        select {
+        case <-v.metricVec.metricMap.stop:
+            return
+        case <-ticker.C:
+        }
         //3 is summary metric.
         v.metricVec.metricMap.pushDocToEs(summaryType, summaryLog)
     }
 }
 
+// ResetAndPush pushes the current values of all summaries in this vector and
+// then deletes them, like Reset. Use it to keep the final values, e.g. at the
+// end of a processing phase. Cancelling ctx aborts the push.
+func (v *SummaryVec) ResetAndPush(ctx context.Context) {
+    v.metricVec.metricMap.resetAndPush(ctx, SUMMARY_TYPE)
+}
+
+// PushContext pushes the current values of all summaries in this vector right
+// away, in addition to the periodic pushes. If any document could not be
+// written, a *PushError is returned. The documents are sent synchronously,
+// also if EsOpts.Async is set. Cancelling ctx aborts the push, e.g. on
+// shutdown, and the returned error then wraps ctx.Err().
+func (v *SummaryVec) PushContext(ctx context.Context) error {
+    return v.metricVec.metricMap.pushContext(ctx, SUMMARY_TYPE)
+}
+
+func (v *SummaryVec) metricType() int {
+    return SUMMARY_TYPE
+}
+
+func (v *SummaryVec) push() {
+    v.metricVec.metricMap.pushDocToEs(SUMMARY_TYPE, v.metricVec.metricMap.logger())
+}
+
 // GetMetricWithLabelValues returns the Summary for the given slice of label
 // values (same order as the VariableLabels in Desc). If that combination of
 // label values is accessed for the first time, a new Summary is created.
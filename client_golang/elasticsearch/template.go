@@ -0,0 +1,144 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// templateFields are the fields of the documents of all metric types mapped by
+// the index template in addition to those checked by SchemaStrict.
+var templateFields = map[string]string{
+    SUM:    "double",
+    COUNT:  "long",
+    MIN:    "double",
+    MAX:    "double",
+    AVG:    "double",
+    LAST:   "double",
+    FQNAME: "keyword",
+    HELP:   "keyword",
+    TYPE:   "keyword",
+}
+
+// validateIndexTemplate returns an error if EsOpts.IndexTemplate is set without
+// a name.
+func validateIndexTemplate(esOpts EsOpts) error {
+    if esOpts.IndexTemplate != nil && esOpts.IndexTemplate.Name == "" {
+        return errors.New("IndexTemplate requires a Name")
+    }
+    return nil
+}
+
+// ensureTemplate creates the index template configured by EsOpts.IndexTemplate
+// unless it exists. Once that has succeeded, it is not repeated. If it fails,
+// nothing must be pushed, and it is repeated on the next push. Cancelling ctx
+// aborts it.
+func (m *metricMap) ensureTemplate(ctx context.Context) error {
+    if m.esOpts.IndexTemplate == nil || m.offline() {
+        return nil
+    }
+    m.pushMtx.Lock()
+    defer m.pushMtx.Unlock()
+
+    if m.templateEnsured {
+        return nil
+    }
+    root, err := rootURL(m.url)
+    if err != nil {
+        return err
+    }
+    u := root + "_template/" + m.esOpts.IndexTemplate.Name
+    _, err = m.request(ctx, "HEAD", u, "application/json", nil)
+    var se *statusError
+    switch {
+    case err == nil:
+        m.templateEnsured = true
+        return nil
+    case !errors.As(err, &se) || se.code != http.StatusNotFound:
+        return fmt.Errorf("not pushing %s, cannot check index template: %v", m.desc.fqName, err)
+    }
+    body, err := m.templateBody()
+    if err != nil {
+        return fmt.Errorf("not pushing %s, cannot build index template: %v", m.desc.fqName, err)
+    }
+    if _, err := m.request(ctx, "PUT", u, "application/json", body); err != nil {
+        return fmt.Errorf("not pushing %s, cannot create index template: %v", m.desc.fqName, err)
+    }
+    m.templateEnsured = true
+    return nil
+}
+
+// templateBody returns the body of the request creating the index template.
+func (m *metricMap) templateBody() ([]byte, error) {
+    index, typ, err := indexAndType(m.url)
+    if err != nil {
+        return nil, err
+    }
+    patterns := m.esOpts.IndexTemplate.Patterns
+    if len(patterns) == 0 {
+        if pattern := m.esOpts.IndexDatePattern; pattern != "" {
+            index = pattern[:strings.IndexByte(pattern+"%", '%')]
+        }
+        patterns = []string{index + "*"}
+    }
+    fields := m.expectedMapping(GAUGE_TYPE)
+    for field, typ := range m.documentFields(templateFields) {
+        fields[field] = typ
+    }
+    var mappings interface{} = map[string]interface{}{
+        "dynamic_templates": []interface{}{
+            map[string]interface{}{"quantiles": map[string]interface{}{
+                "match":   m.esOpts.FieldPrefix + "QUANTILE_*",
+                "mapping": map[string]string{"type": "double"},
+            }},
+        },
+        "properties": templateProperties(fields),
+    }
+    if typ != "" {
+        mappings = map[string]interface{}{typ: mappings}
+    }
+    template := map[string]interface{}{
+        "index_patterns": patterns,
+        "mappings":       mappings,
+    }
+    if settings := m.esOpts.IndexTemplate.Settings; settings != nil {
+        template["settings"] = settings
+    }
+    return json.Marshal(template)
+}
+
+// templateProperties returns the properties of a mapping of the fields, which
+// maps field names to types. Dotted field names denote fields of objects.
+func templateProperties(fields map[string]string) map[string]interface{} {
+    properties := map[string]interface{}{}
+    for field, typ := range fields {
+        props := properties
+        path := strings.Split(field, ".")
+        for _, name := range path[:len(path)-1] {
+            object, ok := props[name].(map[string]interface{})
+            if !ok {
+                object = map[string]interface{}{"properties": map[string]interface{}{}}
+                props[name] = object
+            }
+            props = object["properties"].(map[string]interface{})
+        }
+        props[path[len(path)-1]] = map[string]string{"type": typ}
+    }
+    return properties
+}
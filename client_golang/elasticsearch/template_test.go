@@ -0,0 +1,78 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "reflect"
+    "sync"
+    "testing"
+)
+
+func TestIndexTemplate(t *testing.T) {
+    for _, exists := range []bool{false, true} {
+        var (
+            mtx      sync.Mutex
+            requests []string
+            template map[string]interface{}
+        )
+        server := startServer(func(w http.ResponseWriter, r *http.Request) {
+            mtx.Lock()
+            defer mtx.Unlock()
+            requests = append(requests, r.Method+" "+r.URL.Path)
+            switch {
+            case r.Method == "HEAD" && !exists && template == nil:
+                w.WriteHeader(http.StatusNotFound)
+            case r.Method == "PUT":
+                body, _ := ioutil.ReadAll(r.Body)
+                if err := json.Unmarshal(body, &template); err != nil {
+                    t.Error(err)
+                }
+            case r.URL.Path == "/_bulk":
+                w.Write([]byte(`{"errors":false,"items":[]}`))
+            }
+        })
+
+        vec := newTestCounterVec(server.URL+"/metrics/doc/", EsOpts{
+            IndexTemplate: &IndexTemplate{Name: "metrics", Settings: map[string]interface{}{"number_of_shards": 1}},
+        }, "template_code")
+        vec.WithLabelValues("template").Inc()
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+        vec.pushDocToEs(COUNTER_TYPE, DiscardLogger)
+        server.Close()
+
+        want := []string{"HEAD /_template/metrics", "PUT /_template/metrics", "POST /_bulk", "POST /_bulk"}
+        if exists {
+            want = []string{"HEAD /_template/metrics", "POST /_bulk", "POST /_bulk"}
+        }
+        if !reflect.DeepEqual(requests, want) {
+            t.Errorf("exists %t: got requests %q, want %q", exists, requests, want)
+        }
+        if exists {
+            continue
+        }
+        if got, want := template["index_patterns"], []interface{}{"metrics*"}; !reflect.DeepEqual(got, want) {
+            t.Errorf("got index patterns %v, want %v", got, want)
+        }
+        properties := template["mappings"].(map[string]interface{})["doc"].(map[string]interface{})["properties"]
+        for field, typ := range map[string]string{VALUE: "double", TIMESTAMP: "date", "template_code": "keyword"} {
+            got := properties.(map[string]interface{})[field]
+            if want := map[string]interface{}{"type": typ}; !reflect.DeepEqual(got, want) {
+                t.Errorf("got mapping %v of %s, want %v", got, field, want)
+            }
+        }
+    }
+}
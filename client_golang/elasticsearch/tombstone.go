@@ -0,0 +1,129 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+    "context"
+    "encoding/json"
+    "strconv"
+    "time"
+
+    dto "github.com/Schneizelw/elasticsearch/client_model/go"
+)
+
+// metricTypeOf returns the metric type (COUNTER_TYPE, GAUGE_TYPE, ...) of
+// dtoMetric, or 0 if it is of none of them.
+func metricTypeOf(dtoMetric *dto.Metric) int {
+    switch {
+    case dtoMetric.Counter != nil:
+        return COUNTER_TYPE
+    case dtoMetric.Gauge != nil:
+        return GAUGE_TYPE
+    case dtoMetric.Summary != nil:
+        return SUMMARY_TYPE
+    case dtoMetric.Histogram != nil:
+        return HISTOGRAM_TYPE
+    case dtoMetric.Untyped != nil:
+        return UNTYPED_TYPE
+    }
+    return 0
+}
+
+// tombstone is a deleted series along with its value at its last push. See
+// EsOpts.Tombstones.
+type tombstone struct {
+    series metricWithLabelValues
+    last   lastValue
+}
+
+// pushTombstone pushes the tombstone document of a deleted series.
+func (m *metricMap) pushTombstone(t tombstone) {
+    metricLog := m.logger()
+    ctx, cancel := m.withBatchTimeout(context.Background())
+    defer cancel()
+    batch, err := m.tombstoneBatch(ctx, t, metricLog)
+    if err != nil {
+        metricLog.Errorf("not pushing tombstone of %s: %v", m.desc.fqName, err)
+        return
+    }
+    if batch == nil {
+        return
+    }
+    if m.buffer != nil && m.buffer.enqueue(batch) {
+        return
+    }
+    m.sendBatch(ctx, batch)
+}
+
+// tombstoneBatch returns a batch of the tombstone document of t, or nil if
+// relabeling drops the series. Unlike the documents of buildBatch, the
+// document is neither subject to EsOpts.PushFilter and EsOpts.MetricExpiry nor
+// does it advance the push cycle of EsOpts.FanOut: it is written to the primary
+// index and to all fan-out indexes. The value of a counter is what remained to
+// be pushed since its last push, which is not recorded as last value.
+func (m *metricMap) tombstoneBatch(ctx context.Context, t tombstone, metricLog Logger) (*docBatch, error) {
+    if err := m.verifyCluster(ctx); err != nil {
+        return nil, err
+    }
+    if err := m.ensureTemplate(ctx); err != nil {
+        return nil, err
+    }
+    dtoMetric := dto.Metric{}
+    if err := t.series.metric.Write(&dtoMetric); err != nil {
+        return nil, err
+    }
+    labels := m.seriesLabels(t.series.values)
+    if labels == nil {
+        return nil, nil
+    }
+    metricType := metricTypeOf(&dtoMetric)
+    now := time.Now()
+    primary, err := m.primaryURL(metricType, now)
+    if err != nil {
+        return nil, err
+    }
+    batch := &docBatch{urls: append([]string{primary}, m.fanOutURLs...), log: metricLog}
+
+    if m.esOpts.ValueScale != 0 {
+        scaleMetric(&dtoMetric, m.esOpts.ValueScale)
+    }
+    docMap, fields := m.seriesDoc(labels)
+    docMap[FQNAME] = m.esOpts.FqNamePrefix + m.desc.fqName
+    docMap[HELP] = m.desc.help
+    setTimestamp(docMap, now, m.esOpts)
+    docMap[DELETED_AT] = now.UTC().Format(time.RFC3339)
+    setMetricData(metricType, dtoMetric, docMap)
+    if metricType == COUNTER_TYPE {
+        cur, exact := m.exactValue(t.series.metric)
+        switch {
+        case m.esOpts.CounterMode == CounterCumulative && exact:
+            docMap[VALUE] = json.Number(strconv.FormatUint(cur, 10))
+        case m.esOpts.CounterMode == CounterCumulative:
+        case exact:
+            docMap[VALUE] = t.last.remainingExact(cur)
+        default:
+            docMap[VALUE] = t.last.remaining(docMap[VALUE].(float64))
+        }
+    }
+    doc := esDoc{
+        id:      m.docID(t.series.values),
+        routing: m.routing(t.series.values),
+        body:    docMap,
+        labels:  fields,
+    }
+    if err := m.encodeDoc(batch, doc); err != nil {
+        return nil, err
+    }
+    return batch, nil
+}
@@ -13,8 +13,183 @@
 
 package elasticsearch
 
+import (
+    "context"
+    "math"
+    "sync/atomic"
+    "time"
+
+    dto "github.com/Schneizelw/elasticsearch/client_model/go"
+)
+
+// Untyped is a Metric that represents a single numerical value that can
+// arbitrarily go up and down, like a Gauge, but whose type is unknown. It is
+// useful to mirror an external metric of unknown type.
+//
+// To create Untyped instances, use NewUntypedVec.
+type Untyped interface {
+    Metric
+    Collector
+
+    // Set sets the Untyped metric to an arbitrary value.
+    Set(float64)
+    // Inc increments the Untyped metric by 1.
+    Inc()
+    // Dec decrements the Untyped metric by 1.
+    Dec()
+    // Add adds the given value to the Untyped metric. (The value can be
+    // negative, resulting in a decrease.)
+    Add(float64)
+    // Sub subtracts the given value from the Untyped metric. (The value can
+    // be negative, resulting in an increase.)
+    Sub(float64)
+}
+
 // UntypedOpts is an alias for Opts. See there for doc comments.
 type UntypedOpts Opts
+type UntypedEsOpts EsOpts
+
+// untyped works like gauge, but the collected metric is of type "Untyped".
+type untyped struct {
+    gauge
+}
+
+func (u *untyped) Write(out *dto.Metric) error {
+    val := math.Float64frombits(atomic.LoadUint64(&u.valBits))
+    return populateMetric(UntypedValue, val, u.labelPairs, out)
+}
+
+// UntypedVec is a Collector that bundles a set of Untyped metrics that all
+// share the same Desc, but have different values for their variable labels.
+// Create instances with NewUntypedVec.
+type UntypedVec struct {
+    *metricVec
+}
+
+// NewUntypedVec creates a new UntypedVec based on the provided UntypedOpts and
+// partitioned by the given label names. Like gauges, the values are pushed as
+// they are, not as deltas.
+func NewUntypedVec(opts UntypedOpts, esOpts UntypedEsOpts, labelNames []string) *UntypedVec {
+    desc := NewDesc(
+        BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+        opts.Help,
+        labelNames,
+        opts.ConstLabels,
+    )
+    url := indexURL(EsOpts(esOpts))
+    uv := UntypedVec{
+        metricVec: newMetricVec(desc, url, EsOpts(esOpts), func(lvs ...string) Metric {
+            if len(lvs) != len(desc.variableLabels) {
+                panic(makeInconsistentCardinalityError(desc.fqName, desc.variableLabels, lvs))
+            }
+            result := &untyped{gauge: gauge{desc: desc, labelPairs: makeLabelPairs(desc, lvs)}}
+            result.init(result) // Init self-collection.
+            return result
+        }),
+    }
+    if esOpts.Interval > 0 {
+        go uv.monitor(esOpts.Interval, desc.fqName)
+    }
+    return &uv
+}
+
+func (v *UntypedVec) monitor(second int, fqName string) {
+    ticker := time.NewTicker(time.Duration(second)*time.Second)
+    untypedLog := newLogger(fqName, v.metricVec.metricMap.esOpts)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-v.metricVec.metricMap.stop:
+            return
+        case <-ticker.C:
+        }
+        v.metricVec.metricMap.pushDocToEs(UNTYPED_TYPE, untypedLog)
+    }
+}
+
+// ResetAndPush pushes the current values of all metrics in this vector and
+// then deletes them, like Reset. Cancelling ctx aborts the push.
+func (v *UntypedVec) ResetAndPush(ctx context.Context) {
+    v.metricVec.metricMap.resetAndPush(ctx, UNTYPED_TYPE)
+}
+
+// PushContext pushes the current values of all metrics in this vector right
+// away, in addition to the periodic pushes. See GaugeVec.PushContext.
+func (v *UntypedVec) PushContext(ctx context.Context) error {
+    return v.metricVec.metricMap.pushContext(ctx, UNTYPED_TYPE)
+}
+
+func (v *UntypedVec) metricType() int {
+    return UNTYPED_TYPE
+}
+
+func (v *UntypedVec) push() {
+    v.metricVec.metricMap.pushDocToEs(UNTYPED_TYPE, v.metricVec.metricMap.logger())
+}
+
+// GetMetricWithLabelValues returns the Untyped metric for the given slice of
+// label values (same order as the VariableLabels in Desc). If that combination
+// of label values is accessed for the first time, a new Untyped metric is
+// created. See GaugeVec.GetMetricWithLabelValues.
+func (v *UntypedVec) GetMetricWithLabelValues(lvs ...string) (Untyped, error) {
+    metric, err := v.metricVec.getMetricWithLabelValues(lvs...)
+    if metric != nil {
+        return metric.(Untyped), err
+    }
+    return nil, err
+}
+
+// GetMetricWith returns the Untyped metric for the given Labels map (the label
+// names must match those of the VariableLabels in Desc). If that label map is
+// accessed for the first time, a new Untyped metric is created. See
+// GaugeVec.GetMetricWith.
+func (v *UntypedVec) GetMetricWith(labels Labels) (Untyped, error) {
+    metric, err := v.metricVec.getMetricWith(labels)
+    if metric != nil {
+        return metric.(Untyped), err
+    }
+    return nil, err
+}
+
+// WithLabelValues works as GetMetricWithLabelValues, but panics where
+// GetMetricWithLabelValues would have returned an error.
+func (v *UntypedVec) WithLabelValues(lvs ...string) Untyped {
+    u, err := v.GetMetricWithLabelValues(lvs...)
+    if err != nil {
+        panic(err)
+    }
+    return u
+}
+
+// With works as GetMetricWith, but panics where GetMetricWithLabels would have
+// returned an error.
+func (v *UntypedVec) With(labels Labels) Untyped {
+    u, err := v.GetMetricWith(labels)
+    if err != nil {
+        panic(err)
+    }
+    return u
+}
+
+// CurryWith returns a vector curried with the provided labels. See
+// GaugeVec.CurryWith.
+func (v *UntypedVec) CurryWith(labels Labels) (*UntypedVec, error) {
+    vec, err := v.curryWith(labels)
+    if vec != nil {
+        return &UntypedVec{vec}, err
+    }
+    return nil, err
+}
+
+// MustCurryWith works as CurryWith but panics where CurryWith would have
+// returned an error.
+func (v *UntypedVec) MustCurryWith(labels Labels) *UntypedVec {
+    vec, err := v.CurryWith(labels)
+    if err != nil {
+        panic(err)
+    }
+    return vec
+}
 
 // UntypedFunc works like GaugeFunc but the collected metric is of type
 // "Untyped". UntypedFunc is useful to mirror an external metric of unknown
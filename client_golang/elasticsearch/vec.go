@@ -14,16 +14,23 @@
 package elasticsearch
 
 import (
+    "context"
+    "crypto/rand"
+    "errors"
     "fmt"
+    "math"
+    "os"
     "sync"
+    "sync/atomic"
     "time"
     "bytes"
-    "io"
     "io/ioutil"
     "strconv"
+    "strings"
     "net/http"
+    neturl "net/url"
     "encoding/json"
-    "github.com/cihub/seelog"
+    "github.com/golang/protobuf/proto"
     "github.com/Schneizelw/elasticsearch/common/model"
     dto "github.com/Schneizelw/elasticsearch/client_model/go"
 )
@@ -36,18 +43,64 @@ const (
     COUNT     = "Count"
     FQNAME    = "FqName"
     TIMESTAMP = "Timestamp"
+    EPOCH_TIMESTAMP = "@timestamp"
+    QUANTILE  = "Quantile"
+    BUCKETS   = "Buckets"
+    LE        = "Le"
+    MIN       = "Min"
+    MAX       = "Max"
+    AVG       = "Avg"
+    LAST      = "Last"
+    LOCATION  = "Location"
+    INSTANCE_UUID = "InstanceUUID"
+    RESET     = "Reset"
+    DELETED_AT = "deleted_at"
+    CARDINALITY = "Cardinality"
+    LABELS    = "Labels"
+    SUMMARY   = "Summary"
+    INSTANCE  = "instance"
     QUANTILE_50 = "QUANTILE_50"
     QUANTILE_90 = "QUANTILE_90"
     QUANTILE_99 = "QUANTILE_99"
     METRIC_GAUGE   = "Gauge"
     METRIC_COUNTER = "Counter"
     METRIC_SUMMARY = "Summary"
+    METRIC_HISTOGRAM = "Histogram"
+    METRIC_CARDINALITY = "Cardinality"
+    METRIC_UNTYPED = "Untyped"
+    PERCENTILE_HINTS = "percentile_hints"
     COUNTER_TYPE = 1
     GAUGE_TYPE   = 2
     SUMMARY_TYPE = 3
+    HISTOGRAM_TYPE = 4
+    UNTYPED_TYPE = 5
 )
 
-var lastValueMap = make(map[uint64]float64)
+// instanceUUID identifies this process start. See EsOpts.InstanceUUID.
+var instanceUUID = newUUID()
+
+// instanceName returns the value of the environment variable envVar or, if
+// that is empty, the hostname. See EsOpts.InstanceLabel.
+func instanceName(envVar string) string {
+    if envVar != "" {
+        if name := os.Getenv(envVar); name != "" {
+            return name
+        }
+    }
+    name, _ := os.Hostname()
+    return name
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+    var b [16]byte
+    if _, err := rand.Read(b[:]); err != nil {
+        panic(err)
+    }
+    b[6] = b[6]&0x0f | 0x40
+    b[8] = b[8]&0x3f | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
 
 // metricVec is a Collector to bundle metrics of the same name that differ in
 // their label values. metricVec is not used directly (and therefore
@@ -65,14 +118,82 @@ type metricVec struct {
 }
 
 // newMetricVec returns an initialized metricVec.
-func newMetricVec(desc *Desc, url string, newMetric func(lvs ...string) Metric) *metricVec {
+func newMetricVec(desc *Desc, url string, esOpts EsOpts, newMetric func(lvs ...string) Metric) *metricVec {
+    if err := validateServerless(esOpts); err != nil {
+        panic(err)
+    }
+    if err := validateBulkUpsert(esOpts); err != nil {
+        panic(err)
+    }
+    if err := validateIndexDatePattern(esOpts); err != nil {
+        panic(err)
+    }
+    if err := validateIndexTemplate(esOpts); err != nil {
+        panic(err)
+    }
+    if url != "" {
+        if err := validateIndexURL(url); err != nil {
+            panic(err)
+        }
+    }
+    if err := validateFailoverURLs(esOpts); err != nil {
+        panic(err)
+    }
+    client, err := newClient(esOpts)
+    if err != nil {
+        panic(err)
+    }
+    lastValues := newLastValues()
+    var restoreErr error
+    if esOpts.LastValueFile != "" {
+        lastValues.restored, restoreErr = loadLastValues(esOpts.LastValueFile, desc.fqName)
+    }
+    m := &metricMap{
+        metrics:       map[uint64][]metricWithLabelValues{},
+        url:           url,
+        esOpts:        esOpts,
+        desc:          desc,
+        newMetric:     newMetric,
+        pushMetrics:   newPushMetrics(desc.fqName, lastValues),
+        lastValues:    lastValues,
+        fanOutURLs:    fanOutURLs(esOpts),
+        client:        client,
+        fieldRenames:  esOpts.FieldNames.renames(),
+        failoverRoots: failoverRoots(url, esOpts),
+        stop:          make(chan struct{}),
+    }
+    if restoreErr != nil {
+        m.logger().Warnf("not restoring last values of %s: %v", desc.fqName, restoreErr)
+    }
+    if esOpts.InstanceLabel {
+        m.instance = instanceName(esOpts.InstanceEnvVar)
+    }
+    if m.shipped, err = shipFamily(desc.fqName, esOpts); err != nil {
+        panic(err)
+    }
+    if len(esOpts.Relabel) > 0 {
+        if m.relabel, err = compileRelabel(esOpts.Relabel); err != nil {
+            panic(err)
+        }
+    }
+    if esOpts.ConnectionWarmup {
+        go m.warmUp()
+    }
+    if esOpts.FlushDocs > 0 || esOpts.FlushInterval > 0 {
+        m.flushBuffer = &flushBuffer{
+            maxDocs:   esOpts.FlushDocs,
+            interval:  esOpts.FlushInterval,
+            lastFlush: time.Now(),
+        }
+    }
+    if esOpts.Async {
+        m.buffer = newAsyncBuffer(esOpts.MaxInFlightBytes, m.pushMetrics)
+        go m.buffer.run(func(batch *docBatch) {
+            m.sendBatch(context.Background(), batch)
+        })
+    }
     return &metricVec{
-        metricMap: &metricMap{
-            metrics:   map[uint64][]metricWithLabelValues{},
-            url:       url,
-            desc:      desc,
-            newMetric: newMetric,
-        },
+        metricMap:   m,
         hashAdd:     hashAdd,
         hashAddByte: hashAddByte,
     }
@@ -160,7 +281,7 @@ func (m *metricVec) getMetricWithLabelValues(lvs ...string) (Metric, error) {
         return nil, err
     }
 
-    return m.metricMap.getOrCreateMetricWithLabelValues(h, lvs, m.curry), nil
+    return m.metricMap.getOrCreateMetricWithLabelValues(h, lvs, m.curry)
 }
 
 func (m *metricVec) getMetricWith(labels Labels) (Metric, error) {
@@ -169,7 +290,7 @@ func (m *metricVec) getMetricWith(labels Labels) (Metric, error) {
         return nil, err
     }
 
-    return m.metricMap.getOrCreateMetricWithLabels(h, labels, m.curry), nil
+    return m.metricMap.getOrCreateMetricWithLabels(h, labels, m.curry)
 }
 
 func (m *metricVec) hashLabelValues(vals []string) (uint64, error) {
@@ -224,11 +345,38 @@ func (m *metricVec) hashLabels(labels Labels) (uint64, error) {
     return h, nil
 }
 
+// aggregator is implemented by metrics that aggregate their values between
+// pushes (see EsOpts.GaugeAggregates).
+type aggregator interface {
+    // setAggregates sets the aggregates since the last call in docMap.
+    setAggregates(docMap map[string]interface{})
+}
+
+// exactCounter is implemented by counters that can report their value as an
+// exact integer.
+type exactCounter interface {
+    // exactValue returns the value and true if it is an integer.
+    exactValue() (uint64, bool)
+}
+
+// exactValue returns the exact integer value of the counter metric and true
+// if EsOpts.ExactIntegers applies to it.
+func (m *metricMap) exactValue(metric Metric) (uint64, bool) {
+    if !m.esOpts.ExactIntegers || (m.esOpts.ValueScale != 0 && m.esOpts.ValueScale != 1) {
+        return 0, false
+    }
+    if c, ok := metric.(exactCounter); ok {
+        return c.exactValue()
+    }
+    return 0, false
+}
+
 // metricWithLabelValues provides the metric and its label values for
 // disambiguation on hash collision.
 type metricWithLabelValues struct {
-    values []string
-    metric Metric
+    values   []string
+    metric   Metric
+    activity *seriesActivity // See EsOpts.MetricExpiry.
 }
 
 // curriedLabelValue sets the curried value for a label at the given index.
@@ -243,25 +391,167 @@ type metricMap struct {
     mtx       sync.RWMutex // Protects metrics.
     metrics   map[uint64][]metricWithLabelValues
     url       string
+    esOpts    EsOpts
     desc      *Desc
     newMetric func(labelValues ...string) Metric
+
+    pushMtx         sync.Mutex // Protects lastPush, clusterVerified, templateEnsured, and schemaVerified.
+    lastPush        time.Time
+    clusterVerified bool
+    templateEnsured bool
+    schemaVerified  map[string]bool // Index URLs verified by verifySchema.
+    pushMetrics     *pushMetrics
+
+    fanOutURLs []string     // Index URLs of EsOpts.FanOut, same order.
+    cycles     uint64       // Number of push cycles so far, accessed atomically.
+    client     *http.Client // Shared by all requests to reuse connections.
+    buffer     *asyncBuffer // Only set if EsOpts.Async is set.
+
+    stop     chan struct{} // Closed by Stop.
+    stopOnce sync.Once
+
+    // Root URLs of the node of url and EsOpts.FailoverURLs, nil if no
+    // failover URLs are set.
+    failoverRoots []string
+    failoverNode  int32 // Index of the node that last responded, accessed atomically.
+
+    // Only set if EsOpts.FlushDocs or EsOpts.FlushInterval is set.
+    flushBuffer *flushBuffer
+
+    logOnce      sync.Once
+    log          Logger    // See logger.
+    reservedOnce sync.Once // See warnReservedLabels.
+
+    instance     string            // See EsOpts.InstanceLabel.
+    relabel      relabeler         // Compiled EsOpts.Relabel.
+    fieldRenames map[string]string // See EsOpts.FieldNames.
+    health       pushHealth
+    shipped      bool // See EsOpts.MetricAllowlist.
+
+    // Values of the counter series at their last push, to compute deltas.
+    lastValues *lastValues
+
+    // Number of series per value of each variable label, protected by mtx.
+    // Only tracked if EsOpts.SeriesLimitPerLabel is set.
+    labelValues []map[string]int
+    series      int // Total number of series, protected by mtx.
+
+    // Series not created because of a series limit by hash, with their
+    // detached metric if EsOpts.MaxSeriesPolicy is DropSeries, protected
+    // by mtx. See limitedSeries.
+    dropped map[uint64]Metric
 }
 
-func goRequest(url, data string) error {
-    req, _ := http.NewRequest("PUT", url, bytes.NewReader([]byte(data)))
-    req.Header.Set("Content-Type", "application/json;charset=UTF-8")
-    client := http.Client{}
-    res, err := client.Do(req)
-    if err != nil {
+// authorize sets the Authorization header of req as configured by
+// EsOpts.Credentials and EsOpts.CredentialsProvider.
+func (m *metricMap) authorize(req *http.Request) error {
+    credentials := m.esOpts.Credentials
+    if provide := m.esOpts.CredentialsProvider; provide != nil {
+        var err error
+        if credentials, err = provide(); err != nil {
+            return fmt.Errorf("obtaining credentials: %v", err)
+        }
+    }
+    if m.esOpts.Serverless && !strings.HasPrefix(credentials, apiKeyPrefix) {
+        return errors.New("serverless mode requires API key credentials")
+    }
+    if credentials != "" {
+        req.Header.Set("Authorization", credentials)
+    }
+    return nil
+}
+
+// putDoc PUTs a single document to url. A response with a non-2xx status code
+// yields a *statusError.
+func (m *metricMap) putDoc(ctx context.Context, url, data string) error {
+    body, err := m.request(ctx, "PUT", url, "application/json;charset=UTF-8", []byte(data))
+    if err != nil || m.esOpts.WriteAck == nil {
         return err
     }
-    if res != nil {
-        io.Copy(ioutil.Discard, res.Body)
-        res.Body.Close()
+    var ack WriteAck
+    if err := json.Unmarshal(body, &ack); err != nil {
+        return fmt.Errorf("decoding response of PUT %s: %v", url, err)
     }
+    m.esOpts.WriteAck(ack)
     return nil
 }
 
+// request sends a request with the configured headers and credentials and
+// returns the body of the response. A response with a non-2xx status code
+// yields a *statusError. The body is compressed if EsOpts.Gzip is set. See
+// EsOpts.FailoverURLs for the nodes the request is sent to.
+func (m *metricMap) request(ctx context.Context, method, url, contentType string, body []byte) ([]byte, error) {
+    compress := m.esOpts.Gzip && len(body) > 0
+    if compress {
+        var err error
+        if body, err = gzipBody(body); err != nil {
+            return nil, err
+        }
+    }
+    if len(m.failoverRoots) > 0 {
+        return m.requestFailover(ctx, method, url, contentType, body, compress)
+    }
+    return m.send(ctx, method, url, contentType, body, compress)
+}
+
+// send sends a single request for request, with body compressed if compress
+// is set.
+func (m *metricMap) send(ctx context.Context, method, url, contentType string, body []byte, compress bool) ([]byte, error) {
+    req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    for name, value := range m.esOpts.Headers {
+        req.Header.Set(name, value)
+    }
+    req.Header.Set("Content-Type", contentType)
+    if compress {
+        req.Header.Set("Content-Encoding", "gzip")
+    }
+    if err := m.authorize(req); err != nil {
+        return nil, err
+    }
+    res, err := m.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+    resBody, err := ioutil.ReadAll(res.Body)
+    if res.StatusCode/100 != 2 {
+        return nil, &statusError{
+            method: method, url: url, code: res.StatusCode, status: res.Status,
+            body:       truncate(bytes.TrimSpace(resBody), maxErrorBodyLength),
+            retryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+        }
+    }
+    return resBody, err
+}
+
+// quantileField returns the name of the field of the quantile q in summary
+// documents. It is QUANTILE_ followed by q as a percentage, with "_" instead of
+// a decimal point, which would denote an object path in Elasticsearch, e.g.
+// QUANTILE_50 for 0.5 and QUANTILE_99_9 for 0.999.
+func quantileField(q float64) string {
+    // Shift the decimal point of the shortest representation of q rather
+    // than multiplying by 100, which is inexact, e.g. for 0.29.
+    s := strconv.FormatFloat(q, 'f', -1, 64)
+    whole, frac := s, ""
+    if i := strings.IndexByte(s, '.'); i >= 0 {
+        whole, frac = s[:i], s[i+1:]
+    }
+    if len(frac) < 2 {
+        frac += strings.Repeat("0", 2-len(frac))
+    }
+    percent := strings.TrimLeft(whole+frac[:2], "0")
+    if percent == "" {
+        percent = "0"
+    }
+    if rest := frac[2:]; rest != "" {
+        percent += "_" + rest
+    }
+    return "QUANTILE_" + percent
+}
+
 func setMetricData(metricType int,  dtoMetric dto.Metric, docMap map[string]interface{}) {
     switch metricType {
     case COUNTER_TYPE:
@@ -272,62 +562,717 @@ func setMetricData(metricType int,  dtoMetric dto.Metric, docMap map[string]inte
         dtoGauge := dtoMetric.GetGauge()
         docMap[TYPE] = METRIC_GAUGE
         docMap[VALUE] = dtoGauge.GetValue()
+    case UNTYPED_TYPE:
+        dtoUntyped := dtoMetric.GetUntyped()
+        docMap[TYPE] = METRIC_UNTYPED
+        docMap[VALUE] = dtoUntyped.GetValue()
     case SUMMARY_TYPE:
         dtoSummary := dtoMetric.GetSummary()
         docMap[TYPE] = METRIC_SUMMARY
         docMap[SUM] = dtoSummary.GetSampleSum()
         docMap[COUNT] = dtoSummary.GetSampleCount()
-        dtoQuantiles := dtoSummary.GetQuantile()
-        for _, dtoQuantile := range dtoQuantiles {
-            quantile := dtoQuantile.GetQuantile()
-            value := dtoQuantile.GetValue()
-            if quantile == 0.5 {
-                docMap[QUANTILE_50] = value
-            } else if quantile == 0.9 {
-                docMap[QUANTILE_90] = value
-            } else {
-                docMap[QUANTILE_99] = value
+        for _, dtoQuantile := range dtoSummary.GetQuantile() {
+            docMap[quantileField(dtoQuantile.GetQuantile())] = dtoQuantile.GetValue()
+        }
+    case HISTOGRAM_TYPE:
+        dtoHistogram := dtoMetric.GetHistogram()
+        docMap[TYPE] = METRIC_HISTOGRAM
+        docMap[SUM] = dtoHistogram.GetSampleSum()
+        docMap[COUNT] = dtoHistogram.GetSampleCount()
+        dtoBuckets := dtoHistogram.GetBucket()
+        buckets := make([]map[string]interface{}, 0, len(dtoBuckets))
+        for _, dtoBucket := range dtoBuckets {
+            // The +Inf bucket cannot be represented in JSON. Its count
+            // equals the sample count anyway.
+            if math.IsInf(dtoBucket.GetUpperBound(), +1) {
+                continue
             }
+            buckets = append(buckets, map[string]interface{}{
+                LE:    dtoBucket.GetUpperBound(),
+                COUNT: dtoBucket.GetCumulativeCount(),
+            })
         }
+        docMap[BUCKETS] = buckets
     default:
         //do nothing
     }
 }
 
-func (m *metricMap) pushDocToEs(metricType int, metricLog seelog.LoggerInterface) {
-    docMap := make(map[string]interface{}, len(m.desc.variableLabels))
-    var url string
+// structureSummary moves the sum, count, and quantiles of the summary
+// dtoMetric in docMap, as set by setMetricData, into the SUMMARY object. See
+// SummaryStructured.
+func structureSummary(dtoMetric dto.Metric, docMap map[string]interface{}) {
+    dtoSummary := dtoMetric.GetSummary()
+    summary := map[string]interface{}{
+        SUM:   docMap[SUM],
+        COUNT: docMap[COUNT],
+    }
+    delete(docMap, SUM)
+    delete(docMap, COUNT)
+    for _, dtoQuantile := range dtoSummary.GetQuantile() {
+        field := quantileField(dtoQuantile.GetQuantile())
+        summary[field] = docMap[field]
+        delete(docMap, field)
+    }
+    docMap[SUMMARY] = summary
+}
+
+// sampleCallback passes copies of labels and dtoMetric to EsOpts.SampleCallback
+// so that it cannot affect the push.
+func (m *metricMap) sampleCallback(labels map[string]string, dtoMetric *dto.Metric) {
+    copied := make(map[string]string, len(labels))
+    for label, value := range labels {
+        copied[label] = value
+    }
+    m.esOpts.SampleCallback(m.desc.fqName, copied, *proto.Clone(dtoMetric).(*dto.Metric))
+}
+
+// labelNames returns the names of the constant and variable labels of the
+// metric family, i.e. of the labels of each document.
+func (m *metricMap) labelNames() []string {
+    names := make([]string, 0, len(m.desc.constLabelPairs)+len(m.desc.variableLabels))
+    for _, lp := range m.desc.constLabelPairs {
+        names = append(names, lp.GetName())
+    }
+    return append(names, m.desc.variableLabels...)
+}
+
+// docSeq numbers the generated document _ids, accessed atomically.
+var docSeq uint64
+
+// docID returns the document _id for the series with the given label values.
+// If EsOpts.IdLabel names a variable label with a non-empty value, that value
+// is used. Otherwise, an _id is generated by generateID.
+func (m *metricMap) docID(lvs []string) string {
+    if m.esOpts.IdLabel != "" {
+        for i, label := range m.desc.variableLabels {
+            if label == m.esOpts.IdLabel && lvs[i] != "" {
+                return neturl.PathEscape(lvs[i])
+            }
+        }
+    }
+    return m.generateID(lvs)
+}
+
+// routing returns the routing key of the documents of the series with the
+// given label values, or "" if they are not routed. See EsOpts.RoutingLabel.
+func (m *metricMap) routing(lvs []string) string {
+    if m.esOpts.RoutingLabel != "" {
+        for i, label := range m.desc.variableLabels {
+            if label == m.esOpts.RoutingLabel {
+                return lvs[i]
+            }
+        }
+    }
+    return ""
+}
+
+// seriesKey returns a key of the series with the given label values that, in
+// contrast to the hashes of the metric vector, also covers the metric name. It
+// is stable across processes.
+func (m *metricMap) seriesKey(lvs []string) string {
+    h := hashAdd(hashNew(), m.desc.fqName)
+    for _, lv := range lvs {
+        h = hashAddByte(h, model.SeparatorByte)
+        h = hashAdd(h, lv)
+    }
+    return strconv.FormatUint(h, 16)
+}
+
+// generateID returns a new document _id for the series with the given label
+// values. It combines a hash of the metric name and label values with the
+// current time and a process-wide sequence number, so that documents pushed
+// within the same nanosecond, or on platforms with a coarse clock, do not
+// overwrite each other.
+func (m *metricMap) generateID(lvs []string) string {
+    return m.seriesKey(lvs) + "-" +
+        strconv.FormatInt(time.Now().UnixNano(), 10) + "-" +
+        strconv.FormatUint(atomic.AddUint64(&docSeq, 1), 10)
+}
+
+// fanOutURLs returns the index URLs of the fan-out indices in esOpts.
+func fanOutURLs(esOpts EsOpts) []string {
+    urls := make([]string, 0, len(esOpts.FanOut))
+    for _, f := range esOpts.FanOut {
+        urls = append(urls, fanOutURL(esOpts, f.Index))
+    }
+    return urls
+}
+
+// fanOutURL returns the index URL of the fan-out index on the cluster of the
+// index URL configured by esOpts.
+func fanOutURL(esOpts EsOpts, index string) string {
+    if esOpts.URL == "" {
+        return BuildEsUrl(esOpts.Host, esOpts.Port, index, esOpts.EsType)
+    }
+    root, err := rootURL(esOpts.URL)
+    if err != nil {
+        return ""
+    }
+    u := root + index + "/"
+    if _, typ, err := indexAndType(esOpts.URL); err == nil && typ != "" {
+        u += typ + "/"
+    }
+    return u
+}
+
+// cycleURLs starts a new push cycle at now and returns the index URLs to push
+// to in it, i.e. the primary URL for metricType and those of the fan-out
+// indices due in this cycle.
+func (m *metricMap) cycleURLs(metricType int, now time.Time) ([]string, error) {
+    primary, err := m.primaryURL(metricType, now)
+    if err != nil {
+        return nil, err
+    }
+    cycle := atomic.AddUint64(&m.cycles, 1) - 1
+    urls := []string{primary}
+    for i, f := range m.esOpts.FanOut {
+        if f.Every <= 1 || cycle%uint64(f.Every) == 0 {
+            urls = append(urls, m.fanOutURLs[i])
+        }
+    }
+    return urls, nil
+}
+
+// typeIndexSuffixes are the suffixes of the default per-type index names. See
+// EsOpts.IndexPerType.
+var typeIndexSuffixes = map[int]string{
+    COUNTER_TYPE:   "-counter",
+    GAUGE_TYPE:     "-gauge",
+    SUMMARY_TYPE:   "-summary",
+    HISTOGRAM_TYPE: "-histogram",
+    UNTYPED_TYPE:   "-untyped",
+}
+
+// primaryURL returns the index URL of the metricMap or, if EsOpts.IndexPerType
+// or EsOpts.IndexDatePattern is set, the URL of the index for metricType at
+// now in the same cluster.
+func (m *metricMap) primaryURL(metricType int, now time.Time) (string, error) {
+    if !m.esOpts.IndexPerType && m.esOpts.IndexDatePattern == "" {
+        return m.url, nil
+    }
+    index, typ, err := indexAndType(m.url)
+    if err != nil {
+        return "", err
+    }
+    root, err := rootURL(m.url)
+    if err != nil {
+        return "", err
+    }
+    if m.esOpts.IndexDatePattern != "" {
+        index = m.esOpts.IndexDatePattern
+    }
+    if m.esOpts.IndexPerType {
+        if name, ok := m.esOpts.TypeIndices[metricType]; ok {
+            index = name
+        } else {
+            index += typeIndexSuffixes[metricType]
+        }
+    }
+    if index, err = expandIndexPattern(index, now); err != nil {
+        return "", err
+    }
+    u := root + index + "/"
+    if typ != "" {
+        u += typ + "/"
+    }
+    return u, nil
+}
+
+// pushAllowed reports whether at least EsOpts.MinPushInterval has passed since
+// the last push. If so, now is recorded as the time of the last push.
+func (m *metricMap) pushAllowed(now time.Time) bool {
+    if m.esOpts.MinPushInterval <= 0 {
+        return true
+    }
+    m.pushMtx.Lock()
+    defer m.pushMtx.Unlock()
+
+    if !m.lastPush.IsZero() && now.Sub(m.lastPush) < m.esOpts.MinPushInterval {
+        return false
+    }
+    m.lastPush = now
+    return true
+}
+
+// pushDocToEs pushes the current values of all metrics. Failures are logged
+// and, unless the documents are sent asynchronously (see EsOpts.Async),
+// returned as a *PushError. If EsOpts.FlushDocs or EsOpts.FlushInterval is
+// set, the documents are buffered instead, see bufferBatch.
+func (m *metricMap) pushDocToEs(metricType int, metricLog Logger) error {
+    ctx, cancel := m.withBatchTimeout(context.Background())
+    defer cancel()
+    batch, err := m.pushBatch(ctx, metricType, metricLog)
+    if batch == nil {
+        return err
+    }
+    if m.flushBuffer != nil {
+        return m.bufferBatch(batch)
+    }
+    if m.buffer != nil && m.buffer.enqueue(batch) {
+        return nil
+    }
+    return m.sendBatch(ctx, batch)
+}
+
+// pushContext pushes the current values of all metrics like pushDocToEs, but
+// sends the documents right away, also if EsOpts.Async is set. Once ctx is
+// done, the push is aborted.
+func (m *metricMap) pushContext(ctx context.Context, metricType int) error {
+    ctx, cancel := m.withBatchTimeout(ctx)
+    defer cancel()
+    batch, err := m.pushBatch(ctx, metricType, m.logger())
+    if batch == nil {
+        return err
+    }
+    return m.sendBatch(ctx, batch)
+}
+
+// pushBatch builds the batch of a periodic or explicit push. It returns a nil
+// batch if there is nothing to push, e.g. because of EsOpts.MinPushInterval.
+// Errors are logged. The requests it makes, e.g. to verify the cluster, are
+// aborted once ctx is done.
+func (m *metricMap) pushBatch(ctx context.Context, metricType int, metricLog Logger) (*docBatch, error) {
+    if !m.shipped || !m.pushAllowed(time.Now()) {
+        return nil, nil
+    }
+    if m.esOpts.PingBeforePush && !m.offline() {
+        if err := m.pingBeforePush(ctx); err != nil {
+            metricLog.Error(err)
+            return nil, err
+        }
+    }
+    batch, err := m.buildBatch(ctx, metricType, m.snapshot(), nil, metricLog)
+    if metricType == COUNTER_TYPE && m.esOpts.LastValueTTL > 0 {
+        m.lastValues.prune(time.Now().Add(-m.esOpts.LastValueTTL))
+    }
+    if err == nil && m.esOpts.CardinalityDocument {
+        err = m.addCardinalityDoc(batch)
+    }
+    if err != nil {
+        metricLog.Error(err)
+        return nil, err
+    }
+    return batch, nil
+}
+
+// resetAndPush deletes all metrics and then pushes their last values. Metrics
+// created in the meantime are not part of the push. The lock is not held while
+// pushing, so that a slow cluster does not block the creation of metrics. The
+// documents are sent right away, also if EsOpts.Async is set. If
+// EsOpts.MarkReset is set, they carry the RESET field.
+func (m *metricMap) resetAndPush(ctx context.Context, metricType int) {
+    var extra map[string]interface{}
+    if m.esOpts.MarkReset {
+        extra = map[string]interface{}{RESET: true}
+    }
+    metricLog := m.logger()
+    ctx, cancel := m.withBatchTimeout(ctx)
+    defer cancel()
+
+    m.mtx.Lock()
+    series := m.metrics
+    m.metrics = map[uint64][]metricWithLabelValues{}
+    m.labelValues = nil
+    m.series = 0
+    m.dropped = nil
+    m.mtx.Unlock()
+
+    var (
+        batch *docBatch
+        err   error
+    )
+    if m.shipped {
+        batch, err = m.buildBatch(ctx, metricType, series, extra, metricLog)
+    }
+    // The last values are needed to compute the deltas of the final push.
+    for h := range series {
+        m.lastValues.delete(h)
+    }
+    if !m.shipped {
+        return
+    }
+    if err != nil {
+        metricLog.Error(err)
+        return
+    }
+    m.sendBatch(ctx, batch)
+}
+
+// addCardinalityDoc adds a meta-document holding the current number of series
+// to batch. See EsOpts.CardinalityDocument.
+func (m *metricMap) addCardinalityDoc(batch *docBatch) error {
+    m.mtx.RLock()
+    cardinality := 0
+    for _, lvsSlice := range m.metrics {
+        cardinality += len(lvsSlice)
+    }
+    m.mtx.RUnlock()
+
+    now := time.Now()
+    body := map[string]interface{}{
+        FQNAME:      m.esOpts.FqNamePrefix + m.desc.fqName,
+        TYPE:        METRIC_CARDINALITY,
+        CARDINALITY: cardinality,
+    }
+    setTimestamp(body, now, m.esOpts)
+    return m.encodeDoc(batch, esDoc{
+        id:   m.generateID(nil),
+        body: body,
+    })
+}
+
+// logger returns the logger of pushes not done by the monitor goroutine.
+func (m *metricMap) logger() Logger {
+    m.logOnce.Do(func() {
+        if m.log == nil {
+            m.log = newLogger(m.desc.fqName, m.esOpts)
+        }
+    })
+    return m.log
+}
+
+// buildBatch encodes the documents of the metrics in series, usually all
+// metrics, for a push. The fields in extra are added to every document. The
+// requests it makes, e.g. to verify the cluster, are aborted once ctx is done.
+func (m *metricMap) buildBatch(
+    ctx context.Context, metricType int, series map[uint64][]metricWithLabelValues,
+    extra map[string]interface{}, metricLog Logger,
+) (*docBatch, error) {
+    if err := m.verifyCluster(ctx); err != nil {
+        return nil, err
+    }
+    if err := m.ensureTemplate(ctx); err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    urls, err := m.cycleURLs(metricType, now)
+    if err != nil {
+        return nil, err
+    }
+    if err := m.verifySchema(ctx, metricType, urls); err != nil {
+        return nil, err
+    }
+    m.reservedOnce.Do(func() { m.warnReservedLabels(metricLog) })
+    batch := &docBatch{urls: urls, log: metricLog}
     var curValue float64
-    timestamp := time.Now().UTC().Format(time.RFC3339)
-    for hashValue, lvsSlice := range m.metrics {
+    restoring := m.lastValues.restoring()
+    for hashValue, lvsSlice := range series {
         for _, lvs := range lvsSlice {
-            for index, label := range m.desc.variableLabels {
-                docMap[label] = lvs.values[index]
+            labels := m.seriesLabels(lvs.values)
+            if labels == nil {
+                continue
             }
+            docMap, fields := m.seriesDoc(labels)
             dtoMetric := dto.Metric{}
             if err := lvs.metric.Write(&dtoMetric); err != nil {
                 continue
             }
-            docMap[FQNAME] = m.desc.fqName
+            if m.esOpts.SampleCallback != nil {
+                m.sampleCallback(labels, &dtoMetric)
+            }
+            if m.esOpts.PushFilter != nil && !m.esOpts.PushFilter(dtoMetric) {
+                continue
+            }
+            if m.esOpts.MetricExpiry > 0 && lvs.activity != nil &&
+                lvs.activity.expired(dtoMetric, now, m.esOpts.MetricExpiry) {
+                continue
+            }
+            if m.esOpts.ValueScale != 0 {
+                scaleMetric(&dtoMetric, m.esOpts.ValueScale)
+            }
+            docMap[FQNAME] = m.esOpts.FqNamePrefix + m.desc.fqName
             docMap[HELP] = m.desc.help
-            docMap[TIMESTAMP] = timestamp
+            setTimestamp(docMap, sampleTime(dtoMetric, now), m.esOpts)
+            if m.esOpts.InstanceUUID {
+                docMap[INSTANCE_UUID] = instanceUUID
+            }
+            setSampleTimes(docMap, dtoMetric, now, m.esOpts)
+            for k, v := range extra {
+                docMap[k] = v
+            }
             setMetricData(metricType, dtoMetric, docMap)
-            if metricType == COUNTER_TYPE {
+            if metricType == SUMMARY_TYPE && m.esOpts.SummaryLayout == SummaryStructured &&
+                !m.esOpts.SummaryLongFormat {
+                structureSummary(dtoMetric, docMap)
+            }
+            if metricType == HISTOGRAM_TYPE && len(m.esOpts.PercentileHints) > 0 {
+                docMap[PERCENTILE_HINTS] = m.esOpts.PercentileHints
+            }
+            if a, ok := lvs.metric.(aggregator); ok {
+                a.setAggregates(docMap)
+                if m.esOpts.ValueScale != 0 {
+                    scaleAggregates(docMap, m.esOpts.ValueScale)
+                }
+            }
+            if metricType == COUNTER_TYPE && m.esOpts.CounterMode == CounterCumulative {
+                if cur, ok := m.exactValue(lvs.metric); ok {
+                    docMap[VALUE] = json.Number(strconv.FormatUint(cur, 10))
+                }
+            } else if metricType == COUNTER_TYPE {
+                if restoring {
+                    m.lastValues.restore(hashValue, m.seriesKey(lvs.values))
+                }
                 curValue = docMap[VALUE].(float64)
-                docMap[VALUE] = curValue - lastValueMap[hashValue]
-                lastValueMap[hashValue] = curValue
+                if cur, ok := m.exactValue(lvs.metric); ok {
+                    docMap[VALUE] = m.lastValues.exactDelta(hashValue, cur, m.esOpts.CounterFloatTolerance, now)
+                } else {
+                    docMap[VALUE] = m.lastValues.delta(hashValue, curValue, m.esOpts.CounterFloatTolerance, now)
+                }
             }
-            data, err := json.Marshal(docMap)
-            if err != nil {
-                continue
+            for _, doc := range splitDoc(metricType, dtoMetric, m.docID(lvs.values), docMap, m.esOpts) {
+                doc.routing = m.routing(lvs.values)
+                doc.labels = fields
+                if err := m.encodeDoc(batch, doc); err != nil {
+                    return nil, err
+                }
             }
-            url = m.url + strconv.Itoa(int(time.Now().UnixNano()))
-            //fmt.Println(url, string(data))
-            if err := goRequest(url, string(data)); err != nil {
-                metricLog.Warn(err)
+        }
+    }
+    return batch, nil
+}
+
+// seriesLabels returns the constant and variable labels of the series with the
+// label values lvs, mapped by EsOpts.LabelValueMapper and EsOpts.Relabel. It
+// returns nil if relabeling drops the series.
+func (m *metricMap) seriesLabels(lvs []string) map[string]string {
+    labels := make(map[string]string, len(m.desc.constLabelPairs)+len(m.desc.variableLabels))
+    for _, lp := range m.desc.constLabelPairs {
+        labels[lp.GetName()] = lp.GetValue()
+    }
+    for index, label := range m.desc.variableLabels {
+        labels[label] = lvs[index]
+    }
+    if m.esOpts.LabelValueMapper != nil {
+        for label, value := range labels {
+            labels[label] = m.esOpts.LabelValueMapper(label, value)
+        }
+    }
+    if m.relabel != nil {
+        return m.relabel.process(labels)
+    }
+    return labels
+}
+
+// seriesDoc returns a new document of the series with the given labels,
+// holding the instance and the location, and the fields of the labels, to be
+// added to the document by encodeDoc.
+func (m *metricMap) seriesDoc(labels map[string]string) (docMap, fields map[string]interface{}) {
+    // Fresh maps per series, so that no fields of the previous series,
+    // e.g. of labels dropped by relabeling, leak into them.
+    docMap = make(map[string]interface{}, 8)
+    if m.instance != "" {
+        docMap[INSTANCE] = m.instance
+    }
+    geo := m.esOpts.GeoPoint
+    dropGeo := geo != nil && setLocation(docMap, labels, geo) && geo.DropLabels
+    fields = make(map[string]interface{}, len(labels))
+    for label, value := range labels {
+        if dropGeo && (label == geo.LatLabel || label == geo.LonLabel) {
+            continue
+        }
+        fields[m.labelField(label)] = value
+    }
+    return docMap, fields
+}
+
+// sampleTimeLayout is RFC 3339 with millisecond precision, the precision of
+// Elasticsearch date fields.
+const sampleTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// sampleTime returns the timestamp of the sample dtoMetric if it has a non-zero
+// one, e.g. set by NewMetricWithTimestamp, or else collected, the time the
+// sample was collected at.
+func sampleTime(dtoMetric dto.Metric, collected time.Time) time.Time {
+    if ms := dtoMetric.GetTimestampMs(); ms != 0 {
+        return time.Unix(0, ms*int64(time.Millisecond))
+    }
+    return collected
+}
+
+// setTimestamp sets the timestamp fields of docMap to t: Timestamp as an
+// RFC3339 string and, if esOpts.EpochTimestamp is set, @timestamp as epoch
+// milliseconds instead, or as well with esOpts.LegacyTimestamp.
+func setTimestamp(docMap map[string]interface{}, t time.Time, esOpts EsOpts) {
+    if !esOpts.EpochTimestamp || esOpts.LegacyTimestamp {
+        docMap[TIMESTAMP] = t.UTC().Format(time.RFC3339)
+    }
+    if esOpts.EpochTimestamp {
+        docMap[EPOCH_TIMESTAMP] = t.UnixNano() / int64(time.Millisecond)
+    }
+}
+
+// setSampleTimes sets the fields named by EsOpts.EventTimeField and
+// EsOpts.IngestTimeField, if any. collected is the time the sample was
+// collected at.
+func setSampleTimes(docMap map[string]interface{}, dtoMetric dto.Metric, collected time.Time, esOpts EsOpts) {
+    if esOpts.EventTimeField != "" {
+        docMap[esOpts.EventTimeField] = sampleTime(dtoMetric, collected).UTC().Format(sampleTimeLayout)
+    }
+    if esOpts.IngestTimeField != "" {
+        docMap[esOpts.IngestTimeField] = time.Now().UTC().Format(sampleTimeLayout)
+    }
+}
+
+// setLocation combines the values of the latitude and longitude labels named
+// by geo into the LOCATION field of docMap and reports whether it did. If
+// either is missing, not a number, or out of range, i.e. not within [-90, 90]
+// and [-180, 180] respectively, no LOCATION field is set. NaN and ±Inf are
+// out of range. Elasticsearch would otherwise reject the whole document.
+func setLocation(docMap map[string]interface{}, labels map[string]string, geo *GeoPointLabels) bool {
+    lat, err := strconv.ParseFloat(labels[geo.LatLabel], 64)
+    if err != nil || !(lat >= -90 && lat <= 90) {
+        return false
+    }
+    lon, err := strconv.ParseFloat(labels[geo.LonLabel], 64)
+    if err != nil || !(lon >= -180 && lon <= 180) {
+        return false
+    }
+    docMap[LOCATION] = map[string]float64{"lat": lat, "lon": lon}
+    return true
+}
+
+// prefixFields returns a copy of doc with all top-level field names prefixed,
+// except @timestamp whose name is what makes it useful.
+func prefixFields(doc map[string]interface{}, prefix string) map[string]interface{} {
+    prefixed := make(map[string]interface{}, len(doc))
+    for k, v := range doc {
+        if k == EPOCH_TIMESTAMP {
+            prefixed[k] = v
+            continue
+        }
+        prefixed[prefix+k] = v
+    }
+    return prefixed
+}
+
+// esDoc is a document to be pushed along with its _id and routing key.
+type esDoc struct {
+    id      string
+    routing string
+    body    map[string]interface{}
+    labels  map[string]interface{} // Fields of the labels, see addLabels.
+}
+
+// splitDoc returns the documents to push for the series with the given _id and
+// document docMap. Usually, that is docMap itself, but the long formats split
+// summaries and histograms into one document per quantile or bucket.
+func splitDoc(metricType int, dtoMetric dto.Metric, id string, docMap map[string]interface{}, esOpts EsOpts) []esDoc {
+    var docs []esDoc
+    switch {
+    case metricType == SUMMARY_TYPE && esOpts.SummaryLongFormat:
+        for _, doc := range quantileDocs(dtoMetric, docMap) {
+            docs = append(docs, esDoc{id: id + "_" + strconv.FormatFloat(doc[QUANTILE].(float64), 'g', -1, 64), body: doc})
+        }
+    case metricType == HISTOGRAM_TYPE && esOpts.HistogramLongFormat:
+        for _, doc := range bucketDocs(docMap) {
+            docs = append(docs, esDoc{id: id + "_" + strconv.FormatFloat(doc[LE].(float64), 'g', -1, 64), body: doc})
+        }
+    default:
+        docs = append(docs, esDoc{id: id, body: docMap})
+    }
+    return docs
+}
+
+// encodeDoc validates the document doc, marshals it, and adds it to batch.
+// Failures are logged. An error is only returned if the current push cycle has
+// to be aborted.
+func (m *metricMap) encodeDoc(batch *docBatch, doc esDoc) error {
+    sanitizeFloats(doc.body, m.esOpts.NonFiniteValues)
+    if len(m.fieldRenames) > 0 {
+        doc.body = renameFields(doc.body, m.fieldRenames)
+    }
+    if doc.labels != nil {
+        doc.body = m.addLabels(doc.body, doc.labels)
+    }
+    if prefix := m.esOpts.FieldPrefix; prefix != "" {
+        doc.body = prefixFields(doc.body, prefix)
+    }
+    if validate := m.esOpts.DocumentValidator; validate != nil {
+        if err := validate(doc.body); err != nil {
+            m.pushMetrics.droppedDocs.Inc()
+            batch.log.Warnf("dropping invalid document of %s: %v", m.desc.fqName, err)
+            return nil
+        }
+    }
+    data, err := json.Marshal(doc.body)
+    if err != nil {
+        m.pushMetrics.marshalErrors.Inc()
+        switch m.esOpts.MarshalErrorPolicy {
+        case DiscardAndLog:
+            batch.log.Warnf("dropping document of %s: %v", m.desc.fqName, err)
+        case FailCycle:
+            return fmt.Errorf("aborting push of %s: %v", m.desc.fqName, err)
+        }
+        return nil
+    }
+    batch.add(encodedDoc{id: doc.id, routing: doc.routing, data: data})
+    return nil
+}
+
+// addLabels returns a copy of doc with the fields of the labels added, at the
+// top level or, with EnvelopeNested, in the LABELS object. The labels are
+// added only once the fields set by this package have their final names (see
+// EsOpts.FieldNames), so that they cannot be confused with them. A label
+// overwrites the instance field of the same name.
+func (m *metricMap) addLabels(doc, labels map[string]interface{}) map[string]interface{} {
+    withLabels := make(map[string]interface{}, len(doc)+len(labels))
+    for k, v := range doc {
+        withLabels[k] = v
+    }
+    if m.esOpts.EnvelopeVersion == EnvelopeNested {
+        withLabels[LABELS] = labels
+        return withLabels
+    }
+    for k, v := range labels {
+        withLabels[k] = v
+    }
+    return withLabels
+}
+
+// quantileDocs splits the wide summary document docMap into one document per
+// quantile (long format). Each document carries the quantile in the QUANTILE
+// field and its value in the VALUE field instead of the quantileField fields.
+func quantileDocs(dtoMetric dto.Metric, docMap map[string]interface{}) []map[string]interface{} {
+    dtoQuantiles := dtoMetric.GetSummary().GetQuantile()
+    quantileFields := make(map[string]bool, len(dtoQuantiles))
+    for _, dtoQuantile := range dtoQuantiles {
+        quantileFields[quantileField(dtoQuantile.GetQuantile())] = true
+    }
+    docs := make([]map[string]interface{}, 0, len(dtoQuantiles))
+    for _, dtoQuantile := range dtoQuantiles {
+        doc := make(map[string]interface{}, len(docMap))
+        for k, v := range docMap {
+            if !quantileFields[k] {
+                doc[k] = v
+            }
+        }
+        doc[QUANTILE] = dtoQuantile.GetQuantile()
+        doc[VALUE] = dtoQuantile.GetValue()
+        docs = append(docs, doc)
+    }
+    return docs
+}
+
+// bucketDocs splits the histogram document docMap into one document per bucket
+// (long format). Each document carries the upper bound of the bucket in the LE
+// field and its cumulative count in the COUNT field instead of the BUCKETS
+// array.
+func bucketDocs(docMap map[string]interface{}) []map[string]interface{} {
+    buckets := docMap[BUCKETS].([]map[string]interface{})
+    docs := make([]map[string]interface{}, 0, len(buckets))
+    for _, bucket := range buckets {
+        doc := make(map[string]interface{}, len(docMap))
+        for k, v := range docMap {
+            if k != BUCKETS {
+                doc[k] = v
             }
         }
+        doc[LE] = bucket[LE]
+        doc[COUNT] = bucket[COUNT]
+        docs = append(docs, doc)
     }
+    return docs
 }
 
 // Describe implements Collector. It will send exactly one Desc to the provided
@@ -353,9 +1298,31 @@ func (m *metricMap) Reset() {
     m.mtx.Lock()
     defer m.mtx.Unlock()
 
+    m.reset()
+}
+
+// snapshot returns a copy of the metrics, so that they can be pushed while
+// metrics are created or deleted.
+func (m *metricMap) snapshot() map[uint64][]metricWithLabelValues {
+    m.mtx.RLock()
+    defer m.mtx.RUnlock()
+
+    series := make(map[uint64][]metricWithLabelValues, len(m.metrics))
+    for h, metrics := range m.metrics {
+        series[h] = append([]metricWithLabelValues(nil), metrics...)
+    }
+    return series
+}
+
+// reset deletes all metrics. It must be called while holding the mutex.
+func (m *metricMap) reset() {
     for h := range m.metrics {
         delete(m.metrics, h)
+        m.lastValues.delete(h)
     }
+    m.labelValues = nil
+    m.series = 0
+    m.dropped = nil
 }
 
 // deleteByHashWithLabelValues removes the metric from the hash bucket h. If
@@ -364,6 +1331,12 @@ func (m *metricMap) Reset() {
 func (m *metricMap) deleteByHashWithLabelValues(
     h uint64, lvs []string, curry []curriedLabelValue,
 ) bool {
+    var removed *tombstone
+    defer func() {
+        if removed != nil {
+            m.pushTombstone(*removed)
+        }
+    }()
     m.mtx.Lock()
     defer m.mtx.Unlock()
 
@@ -377,10 +1350,15 @@ func (m *metricMap) deleteByHashWithLabelValues(
         return false
     }
 
+    m.trackLabelValues(metrics[i].values, -1)
+    if m.esOpts.Tombstones {
+        removed = &tombstone{series: metrics[i], last: m.lastValues.get(h)}
+    }
     if len(metrics) > 1 {
         m.metrics[h] = append(metrics[:i], metrics[i+1:]...)
     } else {
         delete(m.metrics, h)
+        m.lastValues.delete(h)
     }
     return true
 }
@@ -391,6 +1369,12 @@ func (m *metricMap) deleteByHashWithLabelValues(
 func (m *metricMap) deleteByHashWithLabels(
     h uint64, labels Labels, curry []curriedLabelValue,
 ) bool {
+    var removed *tombstone
+    defer func() {
+        if removed != nil {
+            m.pushTombstone(*removed)
+        }
+    }()
     m.mtx.Lock()
     defer m.mtx.Unlock()
 
@@ -403,26 +1387,32 @@ func (m *metricMap) deleteByHashWithLabels(
         return false
     }
 
+    m.trackLabelValues(metrics[i].values, -1)
+    if m.esOpts.Tombstones {
+        removed = &tombstone{series: metrics[i], last: m.lastValues.get(h)}
+    }
     if len(metrics) > 1 {
         m.metrics[h] = append(metrics[:i], metrics[i+1:]...)
     } else {
         delete(m.metrics, h)
+        m.lastValues.delete(h)
     }
     return true
 }
 
 // getOrCreateMetricWithLabelValues retrieves the metric by hash and label value
-// or creates it and returns the new one.
+// or creates it and returns the new one. Creating it fails if it would exceed
+// EsOpts.SeriesLimitPerLabel.
 //
 // This function holds the mutex.
 func (m *metricMap) getOrCreateMetricWithLabelValues(
     hash uint64, lvs []string, curry []curriedLabelValue,
-) Metric {
+) (Metric, error) {
     m.mtx.RLock()
     metric, ok := m.getMetricWithHashAndLabelValues(hash, lvs, curry)
     m.mtx.RUnlock()
     if ok {
-        return metric
+        return metric, nil
     }
 
     m.mtx.Lock()
@@ -430,10 +1420,16 @@ func (m *metricMap) getOrCreateMetricWithLabelValues(
     metric, ok = m.getMetricWithHashAndLabelValues(hash, lvs, curry)
     if !ok {
         inlinedLVs := inlineLabelValues(lvs, curry)
+        if err := m.checkSeriesLimit(inlinedLVs); err != nil {
+            return m.limitedSeries(hash, inlinedLVs, err)
+        }
         metric = m.newMetric(inlinedLVs...)
-        m.metrics[hash] = append(m.metrics[hash], metricWithLabelValues{values: inlinedLVs, metric: metric})
+        m.metrics[hash] = append(m.metrics[hash], metricWithLabelValues{
+            values: inlinedLVs, metric: metric, activity: newSeriesActivity(),
+        })
+        m.trackLabelValues(inlinedLVs, 1)
     }
-    return metric
+    return metric, nil
 }
 
 // getOrCreateMetricWithLabelValues retrieves the metric by hash and label value
@@ -442,12 +1438,12 @@ func (m *metricMap) getOrCreateMetricWithLabelValues(
 // This function holds the mutex.
 func (m *metricMap) getOrCreateMetricWithLabels(
     hash uint64, labels Labels, curry []curriedLabelValue,
-) Metric {
+) (Metric, error) {
     m.mtx.RLock()
     metric, ok := m.getMetricWithHashAndLabels(hash, labels, curry)
     m.mtx.RUnlock()
     if ok {
-        return metric
+        return metric, nil
     }
 
     m.mtx.Lock()
@@ -455,10 +1451,16 @@ func (m *metricMap) getOrCreateMetricWithLabels(
     metric, ok = m.getMetricWithHashAndLabels(hash, labels, curry)
     if !ok {
         lvs := extractLabelValues(m.desc, labels, curry)
+        if err := m.checkSeriesLimit(lvs); err != nil {
+            return m.limitedSeries(hash, lvs, err)
+        }
         metric = m.newMetric(lvs...)
-        m.metrics[hash] = append(m.metrics[hash], metricWithLabelValues{values: lvs, metric: metric})
+        m.metrics[hash] = append(m.metrics[hash], metricWithLabelValues{
+            values: lvs, metric: metric, activity: newSeriesActivity(),
+        })
+        m.trackLabelValues(lvs, 1)
     }
-    return metric
+    return metric, nil
 }
 
 // getMetricWithHashAndLabelValues gets a metric while handling possible